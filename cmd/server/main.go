@@ -14,6 +14,7 @@ import (
 
 	"fsqdgo/internal/config"
 	"fsqdgo/internal/download"
+	"fsqdgo/internal/filestore"
 	"fsqdgo/internal/handler"
 	"fsqdgo/internal/storage"
 	"fsqdgo/internal/websocket"
@@ -24,12 +25,53 @@ func main() {
 	SetupLogger(cfg.LogLevel)
 
 	store := storage.New(cfg.DataDir)
+	store.RecoverInterrupted()
 	hub := websocket.NewHub()
-	downloader := download.New(store, hub, cfg.DownloadDir)
+	// Run drains the broadcast channel to connected clients; without it
+	// every Broadcast* call (from HTTP handlers and every download worker)
+	// blocks forever on the first send, since broadcast is unbuffered and
+	// otherwise has no reader.
+	go hub.Run()
+	go hub.StartTicker()
+
+	backend, err := filestore.New(cfg.DownloadDest)
+	if err != nil {
+		slog.Error("Failed to set up download destination", "error", err)
+		os.Exit(1)
+	}
+
+	downloader := download.New(store, hub, backend, cfg.Segments, cfg.MaxConcurrent, cfg.MaxPerHost, cfg.StallTimeout, cfg.MaxBytesPerSec)
 	downloader.Start()
 
+	// Start() only spins up the default queue's workers; any namespaced
+	// queue a prior run created (and storage.New just rehydrated from disk)
+	// needs its own worker pool too, or its pending/downloading items would
+	// sit untouched until someone re-creates the queue by hand.
+	for _, queueCfg := range store.ListQueues() {
+		if queueCfg.Name != storage.DefaultQueue {
+			downloader.AddQueue(queueCfg.Name, queueCfg.MaxConcurrent)
+		}
+	}
+
 	r := chi.NewRouter()
 	r.Handle("/", http.FileServer(http.Dir("static")))
+
+	// Namespaced queue management: create/list queues, then act within one.
+	r.Get("/queues", handler.ListQueuesHandler(store))
+	r.Post("/queues", handler.CreateQueueHandler(store, downloader, hub))
+	r.Route("/queues/{queue}", func(r chi.Router) {
+		r.Get("/", handler.GetQueueHandler(store))
+		r.Post("/", handler.AddToQueueHandler(store, hub))
+		r.Put("/{id}/move", handler.MoveQueueItemHandler(store, hub))
+		r.Put("/{id}/retry", handler.RetryHandler(store, hub))
+		r.Put("/{id}/cancelDownload", handler.CancelDownloadHandler(downloader, hub))
+		r.Delete("/{id}", handler.DeleteQueueItemHandler(store, hub))
+		r.Delete("/failed", handler.ClearFailedHandler(store, hub))
+		r.Delete("/completed", handler.ClearCompletedHandler(store, hub))
+	})
+
+	// Legacy unnamespaced routes operate on storage.DefaultQueue, kept so
+	// existing clients don't need to change.
 	r.Get("/queue", handler.GetQueueHandler(store))
 	r.Post("/queue", handler.AddToQueueHandler(store, hub))
 	r.Put("/queue/{id}/move", handler.MoveQueueItemHandler(store, hub))
@@ -38,6 +80,10 @@ func main() {
 	r.Delete("/queue/{id}", handler.DeleteQueueItemHandler(store, hub))
 	r.Delete("/queue/failed", handler.ClearFailedHandler(store, hub))
 	r.Delete("/queue/completed", handler.ClearCompletedHandler(store, hub))
+	r.Put("/settings/bandwidth", handler.SetBandwidthHandler(downloader, hub))
+	r.Put("/settings/concurrency", handler.SetConcurrencyHandler(downloader))
+	r.Put("/settings/hostConcurrency", handler.SetHostConcurrencyHandler(downloader))
+	r.Get("/settings/hostConcurrency", handler.HostConcurrencyHandler(downloader))
 	r.Get("/ws", hub.WsHandler)
 
 	server := &http.Server{Addr: ":" + cfg.Port, Handler: r}
@@ -55,6 +101,7 @@ func main() {
 		if err := server.Shutdown(ctx); err != nil {
 			slog.Error("Server forced to shutdown")
 		}
+		downloader.Stop()
 		done <- true
 	}()
 
@@ -3,14 +3,22 @@ package config
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	Port        string
-	LogLevel    slog.Level
-	DataDir     string
-	DownloadDir string
+	Port           string
+	LogLevel       slog.Level
+	DataDir        string
+	DownloadDest   string
+	Segments       int
+	MaxConcurrent  int
+	MaxPerHost     int
+	StallTimeout   time.Duration
+	MaxBytesPerSec int64
 }
 
 func LoadConfig() Config {
@@ -39,9 +47,53 @@ func LoadConfig() Config {
 	if DataDir == "" {
 		DataDir = "./data"
 	}
-	DownloadDir := os.Getenv("DOWNLOAD_DIR")
-	if DownloadDir == "" {
-		DownloadDir = "./downloads"
+	DownloadDest := os.Getenv("DOWNLOAD_DEST")
+	if DownloadDest == "" {
+		// DOWNLOAD_DIR is the old, local-disk-only setting; keep honoring it
+		// so existing deployments don't need to change anything.
+		downloadDir := os.Getenv("DOWNLOAD_DIR")
+		if downloadDir == "" {
+			downloadDir = "./downloads"
+		}
+		if abs, err := filepath.Abs(downloadDir); err == nil {
+			downloadDir = abs
+		}
+		DownloadDest = "file://" + downloadDir
 	}
-	return Config{Port: port, LogLevel: logLevel, DataDir: DataDir, DownloadDir: DownloadDir}
+	segments := envInt("SEGMENTS", 4)
+	maxConcurrent := envInt("MAX_CONCURRENT", 2)
+	maxPerHost := envInt("MAX_PER_HOST", 1)
+	stallTimeout := time.Duration(envInt("STALL_TIMEOUT_SECONDS", 60)) * time.Second
+	// MAX_BYTES_PER_SEC <= 0 (including unset) means unlimited.
+	maxBytesPerSec := envInt64("MAX_BYTES_PER_SEC", 0)
+
+	return Config{
+		Port:           port,
+		LogLevel:       logLevel,
+		DataDir:        DataDir,
+		DownloadDest:   DownloadDest,
+		Segments:       segments,
+		MaxConcurrent:  maxConcurrent,
+		MaxPerHost:     maxPerHost,
+		StallTimeout:   stallTimeout,
+		MaxBytesPerSec: maxBytesPerSec,
+	}
+}
+
+func envInt(name string, fallback int) int {
+	if raw := os.Getenv(name); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envInt64(name string, fallback int64) int64 {
+	if raw := os.Getenv(name); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return fallback
 }
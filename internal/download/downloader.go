@@ -1,6 +1,8 @@
 package download
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -8,15 +10,18 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/time/rate"
 
+	"fsqdgo/internal/filestore"
+	"fsqdgo/internal/httpclient"
 	"fsqdgo/internal/models"
 	"fsqdgo/internal/storage"
 	"fsqdgo/internal/websocket"
@@ -25,55 +30,353 @@ import (
 const (
 	chunkSize         = 1024 * 1024
 	progressDelay     = 4 * time.Second
-	userAgent         = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"
+	checkpointDelay   = 2 * time.Second
 	maxRetries        = 5
 	initialRetryDelay = 2 * time.Second
 	maxRetryDelay     = 30 * time.Second
+
+	// segmentThreshold is the minimum file size worth splitting into parallel
+	// ranged requests; smaller files aren't worth the extra connections.
+	segmentThreshold = 50 * 1024 * 1024
+
+	// attemptDeadline bounds a single download attempt so a connection that
+	// keeps trickling just enough bytes to dodge the stall timeout can't run
+	// forever; it still gets retried like any other failed attempt.
+	attemptDeadline = 2 * time.Hour
 )
 
 type Downloader struct {
-	store       *storage.Storage
-	hub         *websocket.Hub
-	downloadDir string
-	cancelCh    map[string]chan struct{}
-	cancelMu    sync.Mutex
+	store         *storage.Storage
+	hub           *websocket.Hub
+	backend       filestore.Backend
+	backends      map[string]filestore.Backend
+	backendsMu    sync.Mutex
+	segments      int
+	maxConcurrent int
+	maxPerHost    int
+	stallTimeout  time.Duration
+	limiter       *rate.Limiter
+
+	hostInFlight map[string]int
+	hostLimits   map[string]int
+	hostMu       sync.Mutex
+
+	cancelFns map[string]context.CancelFunc
+	cancelMu  sync.Mutex
+
+	// queueCtx/queueCancelFns hold the parent context each queue's workers are
+	// derived from, so a single namespace can be torn down (or the whole
+	// downloader stopped) without touching any other queue's workers.
+	// queueWorkers tracks each individual worker's own cancel func, so
+	// SetGlobalConcurrency can grow or shrink a running pool one worker at a
+	// time instead of only being able to set it at AddQueue time.
+	queueCtx       map[string]context.Context
+	queueCancelFns map[string]context.CancelFunc
+	queueWorkers   map[string][]context.CancelFunc
+	queueMu        sync.Mutex
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
-func New(store *storage.Storage, hub *websocket.Hub, downloadDir string) *Downloader {
-	os.MkdirAll(downloadDir, os.ModePerm)
+func New(store *storage.Storage, hub *websocket.Hub, backend filestore.Backend, segments, maxConcurrent, maxPerHost int, stallTimeout time.Duration, maxBytesPerSec int64) *Downloader {
+	if segments < 1 {
+		segments = 1
+	}
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if stallTimeout <= 0 {
+		stallTimeout = 60 * time.Second
+	}
 	return &Downloader{
-		store:       store,
-		hub:         hub,
-		downloadDir: downloadDir,
-		cancelCh:    make(map[string]chan struct{}),
+		store:          store,
+		hub:            hub,
+		backend:        backend,
+		backends:       make(map[string]filestore.Backend),
+		segments:       segments,
+		maxConcurrent:  maxConcurrent,
+		maxPerHost:     maxPerHost,
+		stallTimeout:   stallTimeout,
+		limiter:        rate.NewLimiter(bandwidthLimit(maxBytesPerSec), chunkSize),
+		hostInFlight:   make(map[string]int),
+		hostLimits:     make(map[string]int),
+		cancelFns:      make(map[string]context.CancelFunc),
+		queueCtx:       make(map[string]context.Context),
+		queueCancelFns: make(map[string]context.CancelFunc),
+		queueWorkers:   make(map[string][]context.CancelFunc),
+		stopCh:         make(chan struct{}),
 	}
 }
 
+// bandwidthLimit converts a bytes/sec cap to a rate.Limit, treating a
+// non-positive cap as unlimited.
+func bandwidthLimit(maxBytesPerSec int64) rate.Limit {
+	if maxBytesPerSec <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(maxBytesPerSec)
+}
+
+// SetGlobalBandwidth adjusts the shared download-wide rate limit while
+// downloads are in flight; bytesPerSec <= 0 means unlimited. The limit is
+// applied in copyWithProgress, so it covers single-stream downloads; the
+// segments of a parallel segmented download are not throttled. rate.Limiter is
+// internally synchronized, so callers blocked in WaitN pick up the new rate
+// on their next wait without any extra coordination here.
+func (d *Downloader) SetGlobalBandwidth(bytesPerSec int64) {
+	d.limiter.SetLimit(bandwidthLimit(bytesPerSec))
+}
+
+// Start launches the worker pool for the default queue. Each worker
+// independently claims pending items, respecting the per-host concurrency
+// cap, so MaxConcurrent items can be in flight at once without piling
+// requests on a single origin.
 func (d *Downloader) Start() {
-	go d.worker()
+	d.AddQueue(storage.DefaultQueue, d.maxConcurrent)
+}
+
+// AddQueue launches a worker pool dedicated to queueName, so pausing or
+// scaling one namespace never blocks another's. maxConcurrent <= 0 falls
+// back to the downloader's own default.
+func (d *Downloader) AddQueue(queueName string, maxConcurrent int) {
+	if maxConcurrent <= 0 {
+		maxConcurrent = d.maxConcurrent
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.queueMu.Lock()
+	d.queueCtx[queueName] = ctx
+	d.queueCancelFns[queueName] = cancel
+	d.queueMu.Unlock()
+
+	for i := 0; i < maxConcurrent; i++ {
+		d.addWorker(queueName)
+	}
+}
+
+// SetGlobalConcurrency grows or shrinks the default queue's worker pool to n
+// while it's running, so an operator can turn the dial without restarting
+// the downloader. n <= 0 is treated as 1, the same floor New applies.
+func (d *Downloader) SetGlobalConcurrency(n int) {
+	if n <= 0 {
+		n = 1
+	}
+	d.maxConcurrent = n
+	d.resizeQueue(storage.DefaultQueue, n)
+}
+
+// resizeQueue adds or cancels individual workers in queueName's pool until it
+// has exactly n running, leaving the rest of the pool (and any in-flight
+// downloads on those workers) untouched.
+func (d *Downloader) resizeQueue(queueName string, n int) {
+	for {
+		d.queueMu.Lock()
+		current := len(d.queueWorkers[queueName])
+		d.queueMu.Unlock()
+
+		switch {
+		case current < n:
+			d.addWorker(queueName)
+		case current > n:
+			d.removeWorker(queueName)
+		default:
+			return
+		}
+	}
+}
+
+// addWorker starts one more worker for queueName, derived from that queue's
+// own context so Stop/queue teardown still cancels it.
+func (d *Downloader) addWorker(queueName string) {
+	d.queueMu.Lock()
+	parent, ok := d.queueCtx[queueName]
+	if !ok {
+		d.queueMu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(parent)
+	d.queueWorkers[queueName] = append(d.queueWorkers[queueName], cancel)
+	d.queueMu.Unlock()
+
+	d.wg.Add(1)
+	go d.worker(ctx, queueName)
+}
+
+// removeWorker stops one worker from queueName's pool; it finishes whatever
+// item it's currently claiming/downloading before exiting.
+func (d *Downloader) removeWorker(queueName string) {
+	d.queueMu.Lock()
+	defer d.queueMu.Unlock()
+
+	workers := d.queueWorkers[queueName]
+	if len(workers) == 0 {
+		return
+	}
+	last := workers[len(workers)-1]
+	d.queueWorkers[queueName] = workers[:len(workers)-1]
+	last()
 }
 
-func (d *Downloader) worker() {
+// Stop cancels every queue's workers and every active download, then waits
+// for all workers to exit.
+func (d *Downloader) Stop() {
+	close(d.stopCh)
+
+	d.queueMu.Lock()
+	for name, cancel := range d.queueCancelFns {
+		cancel()
+		delete(d.queueCancelFns, name)
+		delete(d.queueCtx, name)
+		delete(d.queueWorkers, name)
+	}
+	d.queueMu.Unlock()
+
+	d.cancelMu.Lock()
+	for id, cancel := range d.cancelFns {
+		cancel()
+		delete(d.cancelFns, id)
+	}
+	d.cancelMu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *Downloader) worker(ctx context.Context, queueName string) {
+	defer d.wg.Done()
 	for {
-		queue := d.store.GetQueue()
-		if len(queue.Pending) == 0 {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		item, ok := d.store.ClaimPendingItem(queueName, d.tryAcquireHost)
+		if !ok {
 			time.Sleep(2 * time.Second)
 			continue
 		}
 
-		item := queue.Pending[0]
-		d.downloadItem(&item)
+		d.downloadItem(item)
+		d.releaseHost(item.Link)
 	}
 }
 
+// SetHostConcurrency caps in-flight downloads to host at n, overriding the
+// downloader's default MaxPerHost for that host alone - e.g. to go gentler on
+// a host that's been returning 429s without throttling every other origin.
+// n <= 0 clears the override and falls back to the default again.
+func (d *Downloader) SetHostConcurrency(host string, n int) {
+	d.hostMu.Lock()
+	defer d.hostMu.Unlock()
+	if n <= 0 {
+		delete(d.hostLimits, host)
+		return
+	}
+	d.hostLimits[host] = n
+}
+
+// HostInFlight returns a snapshot of how many downloads are currently in
+// flight per host, for observability.
+func (d *Downloader) HostInFlight() map[string]int {
+	d.hostMu.Lock()
+	defer d.hostMu.Unlock()
+	counts := make(map[string]int, len(d.hostInFlight))
+	for host, n := range d.hostInFlight {
+		counts[host] = n
+	}
+	return counts
+}
+
+// hostLimit returns the effective per-host cap for host: its own override if
+// one was set via SetHostConcurrency, otherwise the downloader's default
+// MaxPerHost. Zero/negative means unlimited. Callers must hold d.hostMu.
+func (d *Downloader) hostLimit(host string) int {
+	if limit, ok := d.hostLimits[host]; ok {
+		return limit
+	}
+	return d.maxPerHost
+}
+
+// tryAcquireHost reports whether item's host is under its per-host cap of
+// in-flight downloads, reserving a slot for it if so. This is used as the
+// admission predicate passed into Storage.ClaimPendingItem, which skips over
+// (rather than blocks on) items it rejects - so a single saturated host can
+// never starve items bound for other hosts further back in Pending, giving a
+// fair, round-robin-like claim order across hosts for free.
+func (d *Downloader) tryAcquireHost(item models.Item) bool {
+	host := hostOf(item.Link)
+
+	d.hostMu.Lock()
+	defer d.hostMu.Unlock()
+
+	limit := d.hostLimit(host)
+	if limit <= 0 {
+		return true
+	}
+	if d.hostInFlight[host] >= limit {
+		return false
+	}
+	d.hostInFlight[host]++
+	return true
+}
+
+func (d *Downloader) releaseHost(link string) {
+	host := hostOf(link)
+
+	d.hostMu.Lock()
+	defer d.hostMu.Unlock()
+	if d.hostInFlight[host] > 0 {
+		d.hostInFlight[host]--
+		if d.hostInFlight[host] == 0 {
+			delete(d.hostInFlight, host)
+		}
+	}
+}
+
+// resolveBackend returns the filestore backend for dest, caching it so
+// repeated items bound for the same destination (e.g. the same queue) reuse
+// one connection instead of dialing a fresh S3/SFTP/WebDAV client per item.
+// An empty dest falls back to the downloader's own configured default.
+func (d *Downloader) resolveBackend(dest string) (filestore.Backend, error) {
+	if dest == "" {
+		return d.backend, nil
+	}
+
+	d.backendsMu.Lock()
+	defer d.backendsMu.Unlock()
+
+	if backend, ok := d.backends[dest]; ok {
+		return backend, nil
+	}
+
+	backend, err := filestore.New(dest)
+	if err != nil {
+		return nil, err
+	}
+	d.backends[dest] = backend
+	return backend, nil
+}
+
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	return u.Host
+}
+
 func (d *Downloader) downloadItem(item *models.Item) {
 	slog.Info("Downloading", "id", item.Id, "name", item.Name)
+	d.hub.BroadcastUpdate()
 
-	if _, ok := d.store.MoveToDownloading(item.Id); !ok {
-		slog.Warn("Failed to move to downloading", "id", item.Id)
+	backend, err := d.resolveBackend(item.Destination)
+	if err != nil {
+		d.fail(item, err)
 		return
 	}
-	d.hub.BroadcastUpdate()
 
 	formURL, err := d.getDownloadURL(item.Link)
 	if err != nil {
@@ -81,11 +384,32 @@ func (d *Downloader) downloadItem(item *models.Item) {
 		return
 	}
 
-	cancel := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	d.setCancel(item.Id, cancel)
 	defer d.clearCancel(item.Id)
 
-	if err := d.downloadFile(item, formURL, cancel); err != nil {
+	if err := d.downloadFile(ctx, item, formURL, backend); err != nil {
+		d.fail(item, err)
+		return
+	}
+
+	// Publish the completed temp file under its real name, if the backend
+	// writes to one, so a crash mid-transfer can never leave a half-written
+	// file visible at the final path.
+	if finalizer, ok := backend.(filestore.Finalizer); ok {
+		if err := finalizer.Finalize(sanitizeName(item.Name)); err != nil {
+			d.fail(item, err)
+			return
+		}
+	}
+
+	// Don't mark the item complete until the backend confirms the object is
+	// actually durable - a backend that buffers writes (e.g. the S3 uploader)
+	// could otherwise report success before the object exists.
+	if _, exists, err := backend.Stat(sanitizeName(item.Name)); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("destination backend does not report %q as written", item.Name)
+		}
 		d.fail(item, err)
 		return
 	}
@@ -96,9 +420,15 @@ func (d *Downloader) downloadItem(item *models.Item) {
 }
 
 func (d *Downloader) getDownloadURL(pageURL string) (string, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
 
-	resp, err := client.Get(pageURL)
+	resp, err := httpclient.Client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -127,25 +457,38 @@ func (d *Downloader) getDownloadURL(pageURL string) (string, error) {
 	return formURL.String(), nil
 }
 
-func (d *Downloader) downloadFile(item *models.Item, formURL string, cancel <-chan struct{}) error {
+func (d *Downloader) downloadFile(ctx context.Context, item *models.Item, formURL string, backend filestore.Backend) error {
 	var lastErr error
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		select {
-		case <-cancel:
-			return fmt.Errorf("cancelled")
-		default:
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		slog.Info("Download attempt", "id", item.Id, "attempt", attempt, "max", maxRetries)
 
-		err := d.attemptDownload(item, formURL, cancel)
+		err := d.attemptOnce(ctx, item, formURL, backend)
 		if err == nil {
 			return nil // Success
 		}
 
 		lastErr = err
 
+		// ctx itself being done (as opposed to a per-attempt or per-read
+		// deadline derived from it) means the item was explicitly cancelled
+		// or the downloader is shutting down - don't waste a retry on it.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A permanent error (4xx-equivalent, disk full) won't be fixed by
+		// trying again in-process - return immediately so the caller's
+		// RetryPolicy classification sends it straight to dead-letter instead
+		// of burning the rest of this loop's local attempts first.
+		if isPermanentError(err) {
+			return err
+		}
+
 		if attempt < maxRetries {
 			// Exponential backoff with jitter
 			backoff := float64(initialRetryDelay) * math.Pow(2, float64(attempt-1))
@@ -163,34 +506,165 @@ func (d *Downloader) downloadFile(item *models.Item, formURL string, cancel <-ch
 	return fmt.Errorf("download failed after %d attempts: %w", maxRetries, lastErr)
 }
 
-func (d *Downloader) attemptDownload(item *models.Item, formURL string, cancel <-chan struct{}) error {
-	resp, err := d.doDownloadRequest(formURL)
+// attemptOnce bounds a single download attempt to attemptDeadline, so a
+// connection that keeps the transfer crawling along just fast enough to
+// dodge the stall timeout still eventually gets retried.
+func (d *Downloader) attemptOnce(ctx context.Context, item *models.Item, formURL string, backend filestore.Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, attemptDeadline)
+	defer cancel()
+	return d.attemptDownload(ctx, item, formURL, backend)
+}
+
+func (d *Downloader) attemptDownload(ctx context.Context, item *models.Item, formURL string, backend filestore.Backend) error {
+	// Probe the server for Range support before any progress or validator has
+	// been recorded, so a later retry knows whether it's safe to resume from
+	// BytesDownloaded. A server that sends neither ETag nor Last-Modified is
+	// probed again on each attempt until bytes have been written.
+	if item.BytesDownloaded == 0 && item.ETag == "" && item.LastModified == "" && len(item.Segments) == 0 {
+		resumable, size, etag, lastModified := d.probeResume(ctx, formURL)
+		item.Resumable = resumable
+		item.ETag = etag
+		item.LastModified = lastModified
+		if size > 0 {
+			item.Size = size
+		}
+	}
+
+	if (d.segments > 1 && item.Resumable && item.Size > segmentThreshold) || len(item.Segments) > 0 {
+		return d.attemptSegmentedDownload(ctx, item, formURL, backend)
+	}
+
+	return d.attemptWholeFileDownload(ctx, item, formURL, backend)
+}
+
+func (d *Downloader) attemptWholeFileDownload(ctx context.Context, item *models.Item, formURL string, backend filestore.Backend) error {
+	name := sanitizeName(item.Name)
+
+	if item.Resumable && item.BytesDownloaded > 0 {
+		resp, err := d.doRangedRequest(ctx, formURL, item.BytesDownloaded, ifRangeValidator(item))
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusPartialContent {
+			file, err := backend.OpenAppend(name, item.BytesDownloaded)
+			if err != nil {
+				resp.Body.Close()
+				if errors.Is(err, filestore.ErrAppendUnsupported) {
+					// This backend can never resume (e.g. S3) - fall back to
+					// a full restart instead of failing forever on every
+					// future attempt and manual retry.
+					item.Resumable = false
+					item.BytesDownloaded = 0
+				} else {
+					return err
+				}
+			} else {
+				defer file.Close()
+
+				body, readCtx := d.guardStall(ctx, resp.Body)
+				defer body.Close()
+				return d.copyWithProgress(readCtx, body, file, item)
+			}
+		} else {
+			// Server ignored the range or the underlying file changed (an
+			// ETag/Last-Modified mismatch would show up as a fresh 200 OK) -
+			// fall back to a full restart.
+			resp.Body.Close()
+			item.BytesDownloaded = 0
+		}
+	}
+
+	resp, err := d.doDownloadRequest(ctx, formURL)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
 	if resp.ContentLength > 0 {
 		item.Size = resp.ContentLength
 	}
 
-	filePath := filepath.Join(d.downloadDir, sanitizeName(item.Name))
-	// Overwrite file on each attempt
-	file, err := os.Create(filePath)
+	file, err := backend.Create(name)
 	if err != nil {
+		resp.Body.Close()
 		return err
 	}
 	defer file.Close()
 
-	return d.copyWithProgress(resp.Body, file, item, cancel)
+	body, readCtx := d.guardStall(ctx, resp.Body)
+	defer body.Close()
+	return d.copyWithProgress(readCtx, body, file, item)
+}
+
+// probeResume sends a Range: bytes=0-0 request to discover whether the server
+// honors ranged requests and to obtain a stable ETag/Last-Modified for later
+// If-Range checks. Probe failures are non-fatal: the download just proceeds
+// without resume support.
+func (d *Downloader) probeResume(ctx context.Context, formURL string) (resumable bool, size int64, etag, lastModified string) {
+	req, err := http.NewRequestWithContext(ctx, "POST", formURL, nil)
+	if err != nil {
+		return false, 0, "", ""
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := httpclient.Client.Do(req)
+	if err != nil {
+		slog.Warn("Resume probe failed, continuing without resume support", "error", err)
+		return false, 0, "", ""
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	etag = resp.Header.Get("ETag")
+	lastModified = resp.Header.Get("Last-Modified")
+	resumable = resp.StatusCode == http.StatusPartialContent && resp.Header.Get("Accept-Ranges") == "bytes"
+
+	if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+		if idx := strings.LastIndex(contentRange, "/"); idx != -1 {
+			if n, perr := strconv.ParseInt(contentRange[idx+1:], 10, 64); perr == nil {
+				size = n
+			}
+		}
+	} else if resp.ContentLength > 0 {
+		size = resp.ContentLength
+	}
+
+	return resumable, size, etag, lastModified
+}
+
+// ifRangeValidator returns the value to send as If-Range: item's ETag if it
+// has one (the stronger validator), otherwise its Last-Modified timestamp.
+func ifRangeValidator(item *models.Item) string {
+	if item.ETag != "" {
+		return item.ETag
+	}
+	return item.LastModified
 }
 
-func (d *Downloader) doDownloadRequest(formURL string) (*http.Response, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", formURL, nil)
-	req.Header.Set("User-Agent", userAgent)
+func (d *Downloader) doRangedRequest(ctx context.Context, formURL string, from int64, etag string) (*http.Response, error) {
+	req, _ := http.NewRequestWithContext(ctx, "POST", formURL, nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", from))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
 
-	resp, err := client.Do(req)
+	resp, err := httpclient.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server error: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (d *Downloader) doDownloadRequest(ctx context.Context, formURL string) (*http.Response, error) {
+	req, _ := http.NewRequestWithContext(ctx, "POST", formURL, nil)
+
+	resp, err := httpclient.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -237,20 +711,269 @@ func (d *Downloader) doDownloadRequest(formURL string) (*http.Response, error) {
 	return resp, nil
 }
 
-func (d *Downloader) copyWithProgress(src io.Reader, dst *os.File, item *models.Item, cancel <-chan struct{}) error {
+// attemptSegmentedDownload splits item into d.segments byte ranges and fetches
+// them concurrently, writing each directly into its absolute offset in the
+// pre-allocated destination file. Segment progress is checkpointed to storage
+// so any segment can resume independently after a failure.
+func (d *Downloader) attemptSegmentedDownload(ctx context.Context, item *models.Item, formURL string, backend filestore.Backend) error {
+	rw, ok := backend.(filestore.RandomAccess)
+	if !ok {
+		// The configured backend can only be written to sequentially, so
+		// concurrent ranged writes aren't possible - fall back to one
+		// connection streaming the whole file in order.
+		item.Segments = nil
+		return d.attemptWholeFileDownload(ctx, item, formURL, backend)
+	}
+
+	name := sanitizeName(item.Name)
+
+	if len(item.Segments) == 0 {
+		item.Segments = splitSegments(item.Size, d.segments)
+	}
+
+	first := &item.Segments[0]
+	firstDone := atomic.LoadInt64(&first.Downloaded) >= first.Length
+
+	// Segment 0 doubles as the probe request that tells us whether the
+	// server actually honors ranged requests at all; skip it if a prior
+	// attempt already finished that segment, the same way every other
+	// segment is skipped below, so resuming doesn't re-request a completed
+	// range (which would be a malformed bytes=<past-end>-<end> request) or
+	// spuriously fall back to a full restart.
+	var probeResp *http.Response
+	if !firstDone {
+		start := first.Offset + atomic.LoadInt64(&first.Downloaded)
+		resp, err := d.doBoundedRangedRequest(ctx, formURL, start, first.Offset+first.Length-1, ifRangeValidator(item))
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			// The server accepted the Range header but returned the whole
+			// file anyway - it doesn't really support ranged requests for
+			// this resource. Fall back to the single-stream path.
+			resp.Body.Close()
+			item.Segments = nil
+			item.Resumable = false
+			item.BytesDownloaded = 0
+			return d.attemptWholeFileDownload(ctx, item, formURL, backend)
+		}
+		probeResp = resp
+	}
+
+	file, err := rw.OpenRandomAccess(name, item.Size)
+	if err != nil {
+		if probeResp != nil {
+			probeResp.Body.Close()
+		}
+		return err
+	}
+	defer file.Close()
+
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	var errMu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+
+	recordErr := func(err error) {
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMu.Unlock()
+		abortOnce.Do(func() { close(abort) })
+	}
+
+	if !firstDone {
+		start := first.Offset + atomic.LoadInt64(&first.Downloaded)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, readCtx := d.guardStall(ctx, probeResp.Body)
+			defer body.Close()
+			if err := d.writeSegmentBody(readCtx, body, file, first, start, abort); err != nil {
+				recordErr(err)
+			}
+		}()
+	}
+
+	for i := 1; i < len(item.Segments); i++ {
+		seg := &item.Segments[i]
+		if atomic.LoadInt64(&seg.Downloaded) >= seg.Length {
+			continue
+		}
+		wg.Add(1)
+		go func(seg *models.Segment) {
+			defer wg.Done()
+			if err := d.downloadSegment(ctx, formURL, item, seg, file, abort); err != nil {
+				recordErr(err)
+			}
+		}(seg)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	d.trackSegmentProgress(item, finished)
+	d.store.UpdateDownloadingItem(*item)
+
+	return firstErr
+}
+
+func (d *Downloader) downloadSegment(ctx context.Context, formURL string, item *models.Item, seg *models.Segment, file io.WriterAt, abort <-chan struct{}) error {
+	start := seg.Offset + atomic.LoadInt64(&seg.Downloaded)
+	resp, err := d.doBoundedRangedRequest(ctx, formURL, start, seg.Offset+seg.Length-1, ifRangeValidator(item))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("segment request did not return partial content: %s", resp.Status)
+	}
+
+	body, readCtx := d.guardStall(ctx, resp.Body)
+	defer body.Close()
+	return d.writeSegmentBody(readCtx, body, file, seg, start, abort)
+}
+
+func (d *Downloader) writeSegmentBody(ctx context.Context, body io.Reader, file io.WriterAt, seg *models.Segment, offset int64, abort <-chan struct{}) error {
+	buf := make([]byte, chunkSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-abort:
+			return fmt.Errorf("aborted: sibling segment failed")
+		default:
+		}
+
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := file.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+			atomic.AddInt64(&seg.Downloaded, int64(n))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// trackSegmentProgress polls segment byte counts on a ticker, broadcasting
+// aggregate progress and periodically checkpointing BytesDownloaded, until
+// finished is closed.
+func (d *Downloader) trackSegmentProgress(item *models.Item, finished <-chan struct{}) {
+	ticker := time.NewTicker(progressDelay)
+	defer ticker.Stop()
+	lastCheckpoint := time.Now()
+	progress := &websocket.ProgressUpdate{Type: "progress", ItemID: item.Id}
+
+	for {
+		select {
+		case <-finished:
+			item.BytesDownloaded = sumSegments(item.Segments)
+			progress.Progress = 100
+			d.hub.BroadcastProgress(progress)
+			return
+		case <-ticker.C:
+			total := sumSegments(item.Segments)
+			item.BytesDownloaded = total
+			if item.Size > 0 {
+				progress.Progress = int(float64(total) / float64(item.Size) * 100)
+			}
+			d.hub.BroadcastProgress(progress)
+
+			if time.Since(lastCheckpoint) >= checkpointDelay {
+				d.store.UpdateDownloadingItem(*item)
+				lastCheckpoint = time.Now()
+			}
+		}
+	}
+}
+
+func sumSegments(segments []models.Segment) int64 {
 	var total int64
+	for i := range segments {
+		total += atomic.LoadInt64(&segments[i].Downloaded)
+	}
+	return total
+}
+
+// splitSegments divides size into n roughly-equal byte ranges.
+func splitSegments(size int64, n int) []models.Segment {
+	if n < 1 {
+		n = 1
+	}
+	base := size / int64(n)
+	remainder := size % int64(n)
+
+	segments := make([]models.Segment, 0, n)
+	var offset int64
+	for i := 0; i < n; i++ {
+		length := base
+		if int64(i) < remainder {
+			length++
+		}
+		if length == 0 {
+			continue
+		}
+		segments = append(segments, models.Segment{Offset: offset, Length: length})
+		offset += length
+	}
+	return segments
+}
+
+func (d *Downloader) doBoundedRangedRequest(ctx context.Context, formURL string, start, end int64, etag string) (*http.Response, error) {
+	req, _ := http.NewRequestWithContext(ctx, "POST", formURL, nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
+
+	resp, err := httpclient.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server error: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (d *Downloader) copyWithProgress(ctx context.Context, src io.Reader, dst io.Writer, item *models.Item) error {
+	limiters := []*rate.Limiter{d.limiter}
+	if item.MaxBytesPerSec > 0 {
+		limiters = append(limiters, rate.NewLimiter(rate.Limit(item.MaxBytesPerSec), chunkSize))
+	}
+	src = &rateLimitedReader{ctx: ctx, src: src, limiters: limiters}
+
+	total := item.BytesDownloaded
 	buf := make([]byte, chunkSize)
 	lastReport := time.Now()
+	lastCheckpoint := time.Now()
 	reportedBytes := 0
 
 	progress := &websocket.ProgressUpdate{Type: "progress", ItemID: item.Id}
 
 	for {
 		select {
-		case <-cancel:
+		case <-ctx.Done():
 			progress.DownloadSpeed = ""
 			d.hub.BroadcastProgress(progress)
-			return fmt.Errorf("cancelled")
+			return ctx.Err()
 		default:
 			n, err := src.Read(buf)
 			if n > 0 {
@@ -259,6 +982,7 @@ func (d *Downloader) copyWithProgress(src io.Reader, dst *os.File, item *models.
 				}
 				total += int64(n)
 				reportedBytes += n
+				item.BytesDownloaded = total
 
 				if item.Size > 0 {
 					progress.Progress = int(float64(total) / float64(item.Size) * 100)
@@ -272,11 +996,17 @@ func (d *Downloader) copyWithProgress(src io.Reader, dst *os.File, item *models.
 					lastReport = time.Now()
 					reportedBytes = 0
 				}
+
+				if time.Since(lastCheckpoint) >= checkpointDelay {
+					d.store.UpdateProgress(*item, total)
+					lastCheckpoint = time.Now()
+				}
 			}
 
 			if err == io.EOF {
 				progress.Progress = 100
 				d.hub.BroadcastProgress(progress)
+				d.store.UpdateProgress(*item, total)
 				return nil
 			}
 			if err != nil {
@@ -290,34 +1020,146 @@ func (d *Downloader) copyWithProgress(src io.Reader, dst *os.File, item *models.
 }
 
 func (d *Downloader) fail(item *models.Item, err error) {
-	d.store.MoveToFailed(*item, err.Error())
+	d.store.MoveToFailed(*item, err.Error(), isPermanentError(err))
 	d.hub.BroadcastUpdate()
 	slog.Error("Download failed", "id", item.Id, "error", err)
 }
 
+// permanentErrorPattern matches errors this downloader can't ever recover
+// from by retrying: a 4xx-equivalent response from doDownloadRequest/
+// doRangedRequest/doBoundedRangedRequest, or the disk filling up.
+var permanentErrorPattern = regexp.MustCompile(`server error: 4\d\d|no space left on device`)
+
+// isPermanentError reports whether err should skip straight to the
+// dead-letter list instead of being requeued for a later attempt.
+func isPermanentError(err error) bool {
+	return permanentErrorPattern.MatchString(err.Error())
+}
+
 func (d *Downloader) Cancel(id string) bool {
 	d.cancelMu.Lock()
 	defer d.cancelMu.Unlock()
-	if ch, ok := d.cancelCh[id]; ok {
-		close(ch)
-		delete(d.cancelCh, id)
+	if cancel, ok := d.cancelFns[id]; ok {
+		cancel()
+		delete(d.cancelFns, id)
 		return true
 	}
 	return false
 }
 
-func (d *Downloader) setCancel(id string, ch chan struct{}) {
+func (d *Downloader) setCancel(id string, cancel context.CancelFunc) {
 	d.cancelMu.Lock()
-	d.cancelCh[id] = ch
+	d.cancelFns[id] = cancel
 	d.cancelMu.Unlock()
 }
 
 func (d *Downloader) clearCancel(id string) {
 	d.cancelMu.Lock()
-	delete(d.cancelCh, id)
+	delete(d.cancelFns, id)
 	d.cancelMu.Unlock()
 }
 
+// guardStall wraps body so a Read that makes no progress for d.stallTimeout
+// cancels the returned context, catching half-open TCP connections that
+// would otherwise hang in Read forever. Callers should select on the
+// returned context instead of ctx so a stall only aborts this read, not the
+// whole item.
+func (d *Downloader) guardStall(ctx context.Context, body io.ReadCloser) (io.ReadCloser, context.Context) {
+	stallCtx, cancel := context.WithCancel(ctx)
+	// Cancelling stallCtx alone can't wake a Read already blocked on a dead
+	// connection, so a stall also closes body, which makes that Read return.
+	onStall := func() {
+		cancel()
+		body.Close()
+	}
+	return &stallReader{body: body, timer: newStallTimer(onStall, d.stallTimeout)}, stallCtx
+}
+
+// stallReader cancels its paired stallTimer's context if body.Read doesn't
+// return within the timer's timeout, and resets the timer on every Read that
+// does.
+type stallReader struct {
+	body  io.ReadCloser
+	timer *stallTimer
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.timer.reset()
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.stop()
+	return r.body.Close()
+}
+
+// stallTimer pairs a context.CancelFunc with the time.AfterFunc timer that
+// invokes it after timeout, mirroring the deadline-timer pattern used
+// elsewhere for networked I/O: a single mutex serializes reset and stop,
+// reset stops the timer before re-arming it so a timeout that hasn't fired
+// yet is pushed back, and timeout <= 0 disables the timer entirely. An
+// AfterFunc timer has no channel, so the drain in reset never receives
+// anything, and once the timer has fired a later reset can't undo the cancel.
+type stallTimer struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newStallTimer(cancel context.CancelFunc, timeout time.Duration) *stallTimer {
+	st := &stallTimer{timeout: timeout}
+	if timeout > 0 {
+		st.timer = time.AfterFunc(timeout, cancel)
+	}
+	return st
+}
+
+func (st *stallTimer) reset() {
+	if st.timeout <= 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.timer.Stop() {
+		select {
+		case <-st.timer.C:
+		default:
+		}
+	}
+	st.timer.Reset(st.timeout)
+}
+
+func (st *stallTimer) stop() {
+	if st.timeout <= 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.timer.Stop()
+}
+
+// rateLimitedReader makes each Read wait on every limiter in limiters before
+// returning the bytes it read, so the global bandwidth cap and any per-item
+// override are both enforced against the same buffered chunk.
+type rateLimitedReader struct {
+	ctx      context.Context
+	src      io.Reader
+	limiters []*rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if werr := l.WaitN(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
 func sanitizeName(name string) string {
 	return regexp.MustCompile(`[^a-zA-Z0-9-_. ]`).ReplaceAllString(name, "")
 }
@@ -0,0 +1,63 @@
+package download
+
+import "testing"
+
+func TestSplitSegmentsEvenDivision(t *testing.T) {
+	segments := splitSegments(100, 4)
+
+	if len(segments) != 4 {
+		t.Fatalf("got %d segments, want 4", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.Length != 25 {
+			t.Errorf("segment %d length = %d, want 25", i, seg.Length)
+		}
+	}
+	if segments[0].Offset != 0 || segments[3].Offset != 75 {
+		t.Errorf("segment offsets = %+v, want 0 and 75 at the ends", segments)
+	}
+}
+
+func TestSplitSegmentsRemainderGoesToLeadingSegments(t *testing.T) {
+	segments := splitSegments(10, 3)
+
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	lengths := []int64{segments[0].Length, segments[1].Length, segments[2].Length}
+	if lengths[0] != 4 || lengths[1] != 3 || lengths[2] != 3 {
+		t.Errorf("lengths = %v, want [4 3 3] (the remainder spread across the leading segments)", lengths)
+	}
+
+	var total int64
+	for _, seg := range segments {
+		total += seg.Length
+	}
+	if total != 10 {
+		t.Errorf("total length = %d, want 10", total)
+	}
+}
+
+func TestSplitSegmentsFewerThanOneCollapsesToOne(t *testing.T) {
+	segments := splitSegments(50, 0)
+
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+	if segments[0].Length != 50 || segments[0].Offset != 0 {
+		t.Errorf("segment = %+v, want {Offset:0 Length:50}", segments[0])
+	}
+}
+
+func TestSplitSegmentsSmallerThanCountDropsEmptyOnes(t *testing.T) {
+	segments := splitSegments(2, 5)
+
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2 (empty segments dropped)", len(segments))
+	}
+	for i, seg := range segments {
+		if seg.Length != 1 {
+			t.Errorf("segment %d length = %d, want 1", i, seg.Length)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package filestore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local writes files to a directory on the machine's own disk. Writes land
+// at name+".part" so a crash mid-transfer can never leave a half-written
+// file at its real name; Finalize renames it into place once the transfer
+// is confirmed complete.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	if dir == "" {
+		dir = "."
+	}
+	os.MkdirAll(dir, os.ModePerm)
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.dir, name)
+}
+
+func (l *Local) tempPath(name string) string {
+	return l.path(name) + ".part"
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	return os.Create(l.tempPath(name))
+}
+
+// OpenAppend truncates name's temp file to offset before writing, rather
+// than relying on O_APPEND to land at the file's actual end - the two can
+// diverge if a crash happened between checkpoints, leaving more bytes on
+// disk than the persisted offset accounts for.
+func (l *Local) OpenAppend(name string, offset int64) (io.WriteCloser, error) {
+	file, err := os.OpenFile(l.tempPath(name), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenRandomAccess opens name's temp file for writing at arbitrary offsets,
+// pre-allocating it to size so concurrent writers can each claim a disjoint
+// byte range.
+func (l *Local) OpenRandomAccess(name string, size int64) (RandomWriteCloser, error) {
+	file, err := os.OpenFile(l.tempPath(name), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Finalize atomically renames name's temp file into place, making it visible
+// under its real name for the first time.
+func (l *Local) Finalize(name string) error {
+	return os.Rename(l.tempPath(name), l.path(name))
+}
+
+func (l *Local) Stat(name string) (int64, bool, error) {
+	info, err := os.Stat(l.path(name))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return info.Size(), true, nil
+}
+
+func (l *Local) Remove(name string) error {
+	err := os.Remove(l.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package filestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 uploads files to a bucket/prefix through the SDK's upload manager, which
+// buffers the bytes written through the returned io.WriteCloser into parts of
+// its own part size (5 MiB by default) rather than one part per Write.
+type S3 struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 builds an S3 backend from a URL of the form s3://bucket/prefix?region=...
+func NewS3(u *url.URL) (*S3, error) {
+	bucket := u.Host
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 destination is missing a bucket name")
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	region := u.Query().Get("region")
+	opts := []func(*awsconfig.LoadOptions) error{}
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	return &S3{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: prefix}, nil
+}
+
+func (b *S3) key(name string) string {
+	if b.prefix == "" {
+		return name
+	}
+	return path.Join(b.prefix, name)
+}
+
+// Create streams the written bytes into the object via a multipart upload,
+// completing the upload when the returned writer is closed.
+func (b *S3) Create(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	uploader := manager.NewUploader(b.client)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(b.bucket),
+			Key:    aws.String(b.key(name)),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &s3Writer{pw: pw, done: done}, nil
+}
+
+// OpenAppend is not supported: S3 objects are immutable once a multipart
+// upload completes, and resuming an in-progress multipart upload would
+// require persisting its UploadId, which Item does not track.
+func (b *S3) OpenAppend(name string, offset int64) (io.WriteCloser, error) {
+	return nil, ErrAppendUnsupported
+}
+
+func (b *S3) Stat(name string) (int64, bool, error) {
+	out, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	size := int64(0)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return size, true, nil
+}
+
+func (b *S3) Remove(name string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(name)),
+	})
+	return err
+}
+
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
@@ -15,10 +15,19 @@ import (
 	"fsqdgo/internal/websocket"
 )
 
+// queueName reads the {queue} chi URL param, falling back to the default
+// queue for routes mounted without one (the legacy, pre-namespacing paths).
+func queueName(r *http.Request) string {
+	if q := chi.URLParam(r, "queue"); q != "" {
+		return q
+	}
+	return storage.DefaultQueue
+}
+
 func GetQueueHandler(store *storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		queue := store.GetQueue()
+		queue := store.GetQueue(queueName(r))
 		if err := json.NewEncoder(w).Encode(queue); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(`{"error": "failed to encode queue"}`))
@@ -26,9 +35,39 @@ func GetQueueHandler(store *storage.Storage) http.HandlerFunc {
 	}
 }
 
+func ListQueuesHandler(store *storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.ListQueues())
+	}
+}
+
+func CreateQueueHandler(store *storage.Storage, downloader *download.Downloader, hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var cfg models.QueueConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+			return
+		}
+
+		if err := store.CreateQueue(cfg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		downloader.AddQueue(cfg.Name, cfg.MaxConcurrent)
+		hub.BroadcastUpdate()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+	}
+}
+
 func ClearFailedHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		store.ClearFailedItems()
+		store.ClearFailedItems(queueName(r), r.URL.Query().Get("bucket"))
 		hub.BroadcastUpdate()
 
 		w.Header().Set("Content-Type", "application/json")
@@ -38,7 +77,7 @@ func ClearFailedHandler(store *storage.Storage, hub *websocket.Hub) http.Handler
 
 func ClearCompletedHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		store.ClearCompletedItems()
+		store.ClearCompletedItems(queueName(r))
 		hub.BroadcastUpdate()
 
 		w.Header().Set("Content-Type", "application/json")
@@ -55,7 +94,7 @@ func RetryHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerFunc {
 			return
 		}
 
-		retried := store.RetryDownload(id)
+		retried := store.RetryDownload(queueName(r), id)
 
 		if !retried {
 			w.WriteHeader(http.StatusNotFound)
@@ -95,7 +134,7 @@ func MoveQueueItemHandler(store *storage.Storage, hub *websocket.Hub) http.Handl
 		}
 
 		up := req.Direction == "up"
-		moved := store.MovePendingItem(id, up)
+		moved := store.MovePendingItem(queueName(r), id, up)
 
 		if !moved {
 			w.WriteHeader(http.StatusNotFound)
@@ -119,7 +158,7 @@ func DeleteQueueItemHandler(store *storage.Storage, hub *websocket.Hub) http.Han
 			return
 		}
 
-		deleted := store.RemoveItemById(id)
+		deleted := store.RemoveItemById(queueName(r), id)
 
 		if !deleted {
 			w.WriteHeader(http.StatusNotFound)
@@ -137,7 +176,9 @@ func DeleteQueueItemHandler(store *storage.Storage, hub *websocket.Hub) http.Han
 func AddToQueueHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
-			Link string `json:"link"`
+			Link           string `json:"link"`
+			MaxBytesPerSec int64  `json:"maxBytesPerSec"`
+			Destination    string `json:"destination"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -156,14 +197,16 @@ func AddToQueueHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerF
 		slog.Info("Extracted info", "name", name, "sizeStr", sizeStr, "size", size)
 
 		item := models.Item{
-			Id:      time.Now().Format("20060102150405.999"),
-			Link:    req.Link,
-			Name:    name,
-			Size:    size,
-			AddedAt: time.Now().Format(time.RFC3339),
+			Id:             time.Now().Format("20060102150405.999"),
+			Link:           req.Link,
+			Name:           name,
+			Size:           size,
+			AddedAt:        time.Now().Format(time.RFC3339),
+			MaxBytesPerSec: req.MaxBytesPerSec,
+			Destination:    req.Destination,
 		}
 
-		store.AddPendingItem(item)
+		store.AddPendingItem(queueName(r), item)
 		hub.BroadcastUpdate()
 
 		w.Header().Set("Content-Type", "application/json")
@@ -171,6 +214,71 @@ func AddToQueueHandler(store *storage.Storage, hub *websocket.Hub) http.HandlerF
 	}
 }
 
+func SetBandwidthHandler(downloader *download.Downloader, hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MaxBytesPerSec int64 `json:"maxBytesPerSec"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+			return
+		}
+
+		downloader.SetGlobalBandwidth(req.MaxBytesPerSec)
+		hub.BroadcastBandwidth(req.MaxBytesPerSec)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+	}
+}
+
+func SetConcurrencyHandler(downloader *download.Downloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MaxConcurrent int `json:"maxConcurrent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+			return
+		}
+
+		downloader.SetGlobalConcurrency(req.MaxConcurrent)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+	}
+}
+
+func SetHostConcurrencyHandler(downloader *download.Downloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Host          string `json:"host"`
+			MaxConcurrent int    `json:"maxConcurrent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "host and maxConcurrent are required"})
+			return
+		}
+
+		downloader.SetHostConcurrency(req.Host, req.MaxConcurrent)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+	}
+}
+
+// HostConcurrencyHandler reports how many downloads are currently in flight
+// per host, for observing whether the per-host caps are actually biting.
+func HostConcurrencyHandler(downloader *download.Downloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(downloader.HostInFlight())
+	}
+}
+
 func CancelDownloadHandler(downloader *download.Downloader, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
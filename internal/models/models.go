@@ -8,9 +8,10 @@ type Queue struct {
 }
 
 type QueueForStorage struct {
-	Pending   []Item       `json:"pending"`
-	Completed []Item       `json:"completed"`
-	Failed    []FailedItem `json:"failed"`
+	Downloading []Item       `json:"downloading"`
+	Pending     []Item       `json:"pending"`
+	Completed   []Item       `json:"completed"`
+	Failed      []FailedItem `json:"failed"`
 }
 
 type Item struct {
@@ -19,9 +20,83 @@ type Item struct {
 	Name    string `json:"name"`
 	Size    int64  `json:"size"`
 	AddedAt string `json:"addedAt"`
+
+	// Resume support: set once the server's support for ranged requests has
+	// been probed, so retries can continue from BytesDownloaded instead of
+	// restarting the file from scratch.
+	Resumable       bool   `json:"resumable"`
+	BytesDownloaded int64  `json:"bytesDownloaded"`
+	ETag            string `json:"etag"`
+	LastModified    string `json:"lastModified,omitempty"`
+
+	// Segments is set when the file is large enough to be fetched as
+	// independent byte ranges in parallel; nil for a plain single-stream download.
+	Segments []Segment `json:"segments"`
+
+	// MaxBytesPerSec caps this item's own transfer rate on top of the
+	// downloader's global limit; zero means no per-item cap. Both caps apply
+	// to single-stream downloads only; a parallel segmented download is not
+	// throttled.
+	MaxBytesPerSec int64 `json:"maxBytesPerSec,omitempty"`
+
+	// Queue is the namespaced queue this item belongs to; empty means the
+	// default queue for items persisted before namespacing existed.
+	Queue string `json:"queue,omitempty"`
+
+	// Destination is the URI of the filestore backend this item is written
+	// to (file:///data, s3://bucket/prefix, sftp://host/path, ...). Empty
+	// means the queue's own configured destination, or the downloader's
+	// default if that's empty too.
+	Destination string `json:"destination,omitempty"`
+
+	// Attempts counts how many times this item has been moved to Downloading
+	// and failed. NextAttemptAt holds it out of dispatch (RFC3339, empty
+	// means eligible now) until its backoff delay has elapsed.
+	Attempts      int    `json:"attempts,omitempty"`
+	NextAttemptAt string `json:"nextAttemptAt,omitempty"`
+}
+
+// RetryPolicy controls how many times a failed item is retried and how long
+// it waits between attempts before either landing back in Pending or being
+// dead-lettered into Failed permanently.
+type RetryPolicy struct {
+	MaxAttempts           int     `json:"maxAttempts,omitempty"`
+	InitialBackoffSeconds float64 `json:"initialBackoffSeconds,omitempty"`
+	MaxBackoffSeconds     float64 `json:"maxBackoffSeconds,omitempty"`
+	Multiplier            float64 `json:"multiplier,omitempty"`
+	Jitter                float64 `json:"jitter,omitempty"`
+}
+
+// QueueConfig describes one named, namespaced queue - its own concurrency
+// limit independent of the others, so pausing or throttling one namespace
+// (e.g. "linux-isos") doesn't stall any other.
+type QueueConfig struct {
+	Name          string `json:"name"`
+	MaxConcurrent int    `json:"maxConcurrent,omitempty"`
+
+	// Destination is the default filestore URI for items added to this
+	// queue that don't set their own; empty means fall back to the
+	// downloader's configured default destination.
+	Destination string `json:"destination,omitempty"`
+
+	// RetryPolicy governs retries for items in this queue; its zero value
+	// means "use the package default policy" field by field.
+	RetryPolicy RetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+// Segment is one independently-resumable byte range of a multi-segment download.
+type Segment struct {
+	Offset     int64 `json:"offset"`
+	Length     int64 `json:"length"`
+	Downloaded int64 `json:"downloaded"`
 }
 
 type FailedItem struct {
 	Item
 	Error string `json:"error"`
+
+	// Permanent is true for a non-retryable error (e.g. a 4xx response or a
+	// full disk); false means it was dead-lettered only after Attempts
+	// exhausted the queue's RetryPolicy.
+	Permanent bool `json:"permanent"`
 }
@@ -0,0 +1,41 @@
+package storage
+
+import "fsqdgo/internal/models"
+
+// Backend persists the download queue. It is the seam between Storage's
+// public API and the concrete store (bbolt by default, or JSON for small
+// installs) so the rest of the codebase never has to care which one is in
+// use. Every method commits its change atomically - no caller needs to hold
+// a lock across a read-modify-write sequence.
+type Backend interface {
+	// Load returns a consistent snapshot of every item in the queue.
+	Load() (models.Queue, error)
+
+	AddPendingItem(item models.Item) error
+	RemoveItemById(id string) (bool, error)
+	MovePendingItem(id string, up bool) (bool, error)
+
+	// ClearFailedItems removes Failed entries matching bucket: "" or "all"
+	// for every entry, "transient" or "permanent" to clear only one kind.
+	ClearFailedItems(bucket string) error
+	ClearCompletedItems() error
+	RetryDownload(id string) (bool, error)
+	MoveToDownloading(id string) (models.Item, bool, error)
+
+	// ClaimPendingItem moves the first pending item accepted by allow
+	// straight to Downloading, so two callers can never claim the same item.
+	ClaimPendingItem(allow func(models.Item) bool) (models.Item, bool, error)
+
+	UpdateDownloadingItem(item models.Item) (bool, error)
+	MoveToCompleted(item models.Item) (bool, error)
+
+	// MoveToFailed applies policy to decide whether the item is requeued onto
+	// Pending with a backoff delay, or dead-lettered into Failed; permanent
+	// forces the dead-letter path regardless of remaining attempts.
+	MoveToFailed(item models.Item, errMsg string, permanent bool, policy models.RetryPolicy) (bool, error)
+
+	// RecoverInterrupted moves any items still marked Downloading back onto
+	// the front of Pending, for queues whose Load doesn't already do this at
+	// open time. Returns how many items were moved.
+	RecoverInterrupted() (int, error)
+}
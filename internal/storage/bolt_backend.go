@@ -0,0 +1,593 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+
+	"fsqdgo/internal/models"
+)
+
+var (
+	bucketPending     = []byte("pending")
+	bucketDownloading = []byte("downloading")
+	bucketCompleted   = []byte("completed")
+	bucketFailed      = []byte("failed")
+)
+
+// boltBackend persists the queue in an embedded bbolt database. Each bucket
+// is keyed by an auto-incrementing sequence number so cursor order matches
+// insertion order, and every mutation commits as a single ACID write
+// transaction instead of re-marshaling the whole queue on every change.
+type boltBackend struct {
+	db *bbolt.DB
+}
+
+func newBoltBackend(dataDir string) (*boltBackend, error) {
+	dbPath := filepath.Join(dataDir, "queue.db")
+	firstRun := !fileExists(dbPath)
+
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketPending, bucketDownloading, bucketCompleted, bucketFailed} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	backend := &boltBackend{db: db}
+	if firstRun {
+		if err := backend.migrateFromJSON(filepath.Join(dataDir, "queue.json")); err != nil {
+			slog.Warn("Failed to migrate legacy queue.json", "error", err)
+		}
+	}
+	return backend, nil
+}
+
+// migrateFromJSON imports a legacy queue.json the first time the bolt db is
+// opened, then renames it out of the way so this only ever runs once.
+func (b *boltBackend) migrateFromJSON(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var data models.QueueForStorage
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		return err
+	}
+
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		// Anything still marked downloading when the old store last saved
+		// did not finish cleanly; put it back on the front of pending so the
+		// worker resumes it, same as the legacy loader did.
+		pending := append(append([]models.Item{}, data.Downloading...), data.Pending...)
+		if err := putAllItems(tx.Bucket(bucketPending), pending); err != nil {
+			return err
+		}
+		if err := putAllItems(tx.Bucket(bucketCompleted), data.Completed); err != nil {
+			return err
+		}
+		for _, failedItem := range data.Failed {
+			if err := putItem(tx.Bucket(bucketFailed), failedItem); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Migrated legacy queue.json into bbolt store", "path", path)
+	return os.Rename(path, path+".migrated")
+}
+
+func (b *boltBackend) Load() (models.Queue, error) {
+	var queue models.Queue
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		if queue.Pending, err = loadItems(tx.Bucket(bucketPending)); err != nil {
+			return err
+		}
+		if queue.Downloading, err = loadItems(tx.Bucket(bucketDownloading)); err != nil {
+			return err
+		}
+		if queue.Completed, err = loadItems(tx.Bucket(bucketCompleted)); err != nil {
+			return err
+		}
+		queue.Failed, err = loadFailedItems(tx.Bucket(bucketFailed))
+		return err
+	})
+	return queue, err
+}
+
+func (b *boltBackend) AddPendingItem(item models.Item) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return putItem(tx.Bucket(bucketPending), item)
+	})
+}
+
+func (b *boltBackend) RemoveItemById(id string) (bool, error) {
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketPending, bucketCompleted, bucketFailed} {
+			ok, err := deleteByID(tx.Bucket(name), id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (b *boltBackend) MovePendingItem(id string, up bool) (bool, error) {
+	moved := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketPending)
+		keys, values, err := allEntries(bucket)
+		if err != nil {
+			return err
+		}
+
+		idx := -1
+		for i, v := range values {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if item.Id == id {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return nil
+		}
+
+		var swapWith int
+		switch {
+		case up && idx > 0:
+			swapWith = idx - 1
+		case !up && idx < len(values)-1:
+			swapWith = idx + 1
+		default:
+			return nil
+		}
+
+		// Swap the values stored under the two keys so cursor order reflects
+		// the new position while each entry keeps its own stable key.
+		if err := bucket.Put(keys[idx], values[swapWith]); err != nil {
+			return err
+		}
+		if err := bucket.Put(keys[swapWith], values[idx]); err != nil {
+			return err
+		}
+		moved = true
+		return nil
+	})
+	return moved, err
+}
+
+func (b *boltBackend) ClearFailedItems(bucket string) error {
+	if bucket == "" || bucket == "all" {
+		return clearBucket(b.db, bucketFailed)
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		failedBkt := tx.Bucket(bucketFailed)
+		c := failedBkt.Cursor()
+		var toDelete [][]byte
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, err := decodeFailedItem(v)
+			if err != nil {
+				return err
+			}
+			if matchesBucket(item, bucket) {
+				toDelete = append(toDelete, append([]byte{}, k...))
+			}
+		}
+		for _, k := range toDelete {
+			if err := failedBkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBackend) ClearCompletedItems() error {
+	return clearBucket(b.db, bucketCompleted)
+}
+
+func (b *boltBackend) RetryDownload(id string) (bool, error) {
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		failedBucket := tx.Bucket(bucketFailed)
+		c := failedBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			failedItem, err := decodeFailedItem(v)
+			if err != nil {
+				return err
+			}
+			if failedItem.Id != id {
+				continue
+			}
+
+			if err := failedBucket.Delete(k); err != nil {
+				return err
+			}
+			// Keep the failed item's resume state so a manual retry continues
+			// from BytesDownloaded instead of restarting the file from scratch.
+			if err := putItem(tx.Bucket(bucketPending), failedItem.Item); err != nil {
+				return err
+			}
+			found = true
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (b *boltBackend) MoveToDownloading(id string) (models.Item, bool, error) {
+	var result models.Item
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketPending)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if item.Id != id {
+				continue
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			if err := putItem(tx.Bucket(bucketDownloading), item); err != nil {
+				return err
+			}
+			result, found = item, true
+			return nil
+		}
+		return nil
+	})
+	return result, found, err
+}
+
+func (b *boltBackend) ClaimPendingItem(allow func(models.Item) bool) (models.Item, bool, error) {
+	var result models.Item
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketPending)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if !allow(item) {
+				continue
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			if err := putItem(tx.Bucket(bucketDownloading), item); err != nil {
+				return err
+			}
+			result, found = item, true
+			return nil
+		}
+		return nil
+	})
+	return result, found, err
+}
+
+func (b *boltBackend) UpdateDownloadingItem(item models.Item) (bool, error) {
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketDownloading)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			existing, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if existing.Id != item.Id {
+				continue
+			}
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(k, data); err != nil {
+				return err
+			}
+			found = true
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (b *boltBackend) MoveToCompleted(downloadedItem models.Item) (bool, error) {
+	return b.moveDownloading(downloadedItem.Id, func(tx *bbolt.Tx, item models.Item) error {
+		return putItem(tx.Bucket(bucketCompleted), item)
+	})
+}
+
+func (b *boltBackend) MoveToFailed(failedItem models.Item, errMsg string, permanent bool, policy models.RetryPolicy) (bool, error) {
+	found, err := b.moveDownloading(failedItem.Id, func(tx *bbolt.Tx, item models.Item) error {
+		return putRetryDecision(tx, item, errMsg, permanent, policy)
+	})
+	if err != nil || found {
+		return found, err
+	}
+
+	// Not currently downloading (e.g. it failed before a worker claimed it) -
+	// check pending too, mirroring the legacy JSON store's fallback.
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketPending)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, derr := decodeItem(v)
+			if derr != nil {
+				return derr
+			}
+			if item.Id != failedItem.Id {
+				continue
+			}
+			if derr := bucket.Delete(k); derr != nil {
+				return derr
+			}
+			if derr := putRetryDecision(tx, item, errMsg, permanent, policy); derr != nil {
+				return derr
+			}
+			found = true
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+// putRetryDecision applies decideRetry to item and writes the result into
+// whichever bucket (Pending or Failed) it landed in, within tx.
+func putRetryDecision(tx *bbolt.Tx, item models.Item, errMsg string, permanent bool, policy models.RetryPolicy) error {
+	requeued, deadLettered := decideRetry(item, errMsg, permanent, policy)
+	if deadLettered != nil {
+		return putItem(tx.Bucket(bucketFailed), *deadLettered)
+	}
+	return putItem(tx.Bucket(bucketPending), requeued)
+}
+
+// moveDownloading finds the Downloading entry matching id, deletes it, and
+// hands the decoded item to put within the same transaction.
+func (b *boltBackend) moveDownloading(id string, put func(tx *bbolt.Tx, item models.Item) error) (bool, error) {
+	found := false
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketDownloading)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if item.Id != id {
+				continue
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			if err := put(tx, item); err != nil {
+				return err
+			}
+			found = true
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+// RecoverInterrupted moves every entry still in bucketDownloading back onto
+// the front of bucketPending. Unlike jsonBackend, Load doesn't do this
+// implicitly, so a crash would otherwise leave items stuck in Downloading
+// forever.
+func (b *boltBackend) RecoverInterrupted() (int, error) {
+	moved := 0
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		downloading := tx.Bucket(bucketDownloading)
+		pending := tx.Bucket(bucketPending)
+
+		keys, values, err := allEntries(downloading)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		// Reinsert at the front of pending (lowest sequence numbers) so
+		// recovered items are retried before anything queued after the crash.
+		items := make([]models.Item, 0, len(values))
+		for _, v := range values {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+
+		existingKeys, existingValues, err := allEntries(pending)
+		if err != nil {
+			return err
+		}
+		for _, k := range existingKeys {
+			if err := pending.Delete(k); err != nil {
+				return err
+			}
+		}
+		if err := putAllItems(pending, items); err != nil {
+			return err
+		}
+		for _, v := range existingValues {
+			item, err := decodeItem(v)
+			if err != nil {
+				return err
+			}
+			if err := putItem(pending, item); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range keys {
+			if err := downloading.Delete(k); err != nil {
+				return err
+			}
+		}
+		moved = len(keys)
+		return nil
+	})
+	return moved, err
+}
+
+func clearBucket(db *bbolt.DB, name []byte) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(name); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket(name)
+		return err
+	})
+}
+
+func putItem(bucket *bbolt.Bucket, v any) error {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(seqKey(seq), data)
+}
+
+func putAllItems(bucket *bbolt.Bucket, items []models.Item) error {
+	for _, item := range items {
+		if err := putItem(bucket, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func decodeItem(data []byte) (models.Item, error) {
+	var item models.Item
+	err := json.Unmarshal(data, &item)
+	return item, err
+}
+
+func decodeFailedItem(data []byte) (models.FailedItem, error) {
+	var item models.FailedItem
+	err := json.Unmarshal(data, &item)
+	return item, err
+}
+
+// idOnly decodes just enough of an Item or FailedItem to match by id without
+// caring which of the two the bucket holds - both embed the same "id" field.
+type idOnly struct {
+	Id string `json:"id"`
+}
+
+func deleteByID(bucket *bbolt.Bucket, id string) (bool, error) {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var decoded idOnly
+		if err := json.Unmarshal(v, &decoded); err != nil {
+			return false, err
+		}
+		if decoded.Id == id {
+			return true, bucket.Delete(k)
+		}
+	}
+	return false, nil
+}
+
+// allEntries copies every key/value pair out of bucket, since the slices
+// returned by a bbolt Cursor are only valid for the life of the transaction
+// and callers here mutate the bucket after collecting them.
+func allEntries(bucket *bbolt.Bucket) (keys [][]byte, values [][]byte, err error) {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+		values = append(values, append([]byte{}, v...))
+	}
+	return keys, values, nil
+}
+
+func loadItems(bucket *bbolt.Bucket) ([]models.Item, error) {
+	items := []models.Item{}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		item, err := decodeItem(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func loadFailedItems(bucket *bbolt.Bucket) ([]models.FailedItem, error) {
+	items := []models.FailedItem{}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		item, err := decodeFailedItem(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"fsqdgo/internal/models"
+)
+
+// jsonBackend is the original whole-file store: every mutation rewrites
+// queue.json in full. It's kept around for small installs that would rather
+// keep a plain-text queue than pull in bbolt.
+type jsonBackend struct {
+	mu       sync.RWMutex
+	filePath string
+	queue    models.Queue
+}
+
+func newJSONBackend(dataDir string) *jsonBackend {
+	b := &jsonBackend{filePath: filepath.Join(dataDir, "queue.json")}
+	queue, err := b.loadQueue()
+	if err != nil {
+		queue = models.Queue{}
+	}
+	b.queue = queue
+	return b
+}
+
+// saveQueue is always called with b.mu held, and callers wait for it to
+// return rather than spawning it as a goroutine - data's slices share backing
+// arrays with b.queue, so serializing it concurrently with another mutation
+// would race.
+func (b *jsonBackend) saveQueue(data models.Queue) error {
+	storageData := models.QueueForStorage{
+		Downloading: data.Downloading,
+		Pending:     data.Pending,
+		Completed:   data.Completed,
+		Failed:      data.Failed,
+	}
+
+	file, err := os.Create(b.filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(storageData)
+}
+
+func (b *jsonBackend) loadQueue() (models.Queue, error) {
+	var data models.QueueForStorage
+	file, err := os.Open(b.filePath)
+	if err != nil {
+		return models.Queue{}, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		return models.Queue{}, err
+	}
+
+	// Anything still marked as downloading when we last saved did not finish
+	// cleanly (crash or restart). Put it back on the front of the pending
+	// queue so the worker picks it up again; BytesDownloaded/ETag/Resumable
+	// are preserved so the download resumes instead of starting over.
+	pending := append(append([]models.Item{}, data.Downloading...), data.Pending...)
+
+	return models.Queue{
+		Downloading: []models.Item{},
+		Pending:     pending,
+		Completed:   data.Completed,
+		Failed:      data.Failed,
+	}, nil
+}
+
+func (b *jsonBackend) Load() (models.Queue, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.queue, nil
+}
+
+func (b *jsonBackend) AddPendingItem(item models.Item) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queue.Pending = append(b.queue.Pending, item)
+	b.saveQueue(b.queue)
+	return nil
+}
+
+func (b *jsonBackend) RemoveItemById(id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Pending {
+		if item.Id == id {
+			b.queue.Pending = append(b.queue.Pending[:i], b.queue.Pending[i+1:]...)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+
+	for i, item := range b.queue.Completed {
+		if item.Id == id {
+			b.queue.Completed = append(b.queue.Completed[:i], b.queue.Completed[i+1:]...)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+
+	for i, item := range b.queue.Failed {
+		if item.Id == id {
+			b.queue.Failed = append(b.queue.Failed[:i], b.queue.Failed[i+1:]...)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (b *jsonBackend) MovePendingItem(id string, up bool) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Pending {
+		if item.Id == id {
+			if up && i > 0 {
+				b.queue.Pending[i-1], b.queue.Pending[i] = b.queue.Pending[i], b.queue.Pending[i-1]
+				b.saveQueue(b.queue)
+				return true, nil
+			} else if !up && i < len(b.queue.Pending)-1 {
+				b.queue.Pending[i], b.queue.Pending[i+1] = b.queue.Pending[i+1], b.queue.Pending[i]
+				b.saveQueue(b.queue)
+				return true, nil
+			}
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *jsonBackend) ClearFailedItems(bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := make([]models.FailedItem, 0, len(b.queue.Failed))
+	for _, item := range b.queue.Failed {
+		if !matchesBucket(item, bucket) {
+			kept = append(kept, item)
+		}
+	}
+	b.queue.Failed = kept
+	b.saveQueue(b.queue)
+	return nil
+}
+
+func (b *jsonBackend) ClearCompletedItems() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queue.Completed = []models.Item{}
+	b.saveQueue(b.queue)
+	return nil
+}
+
+func (b *jsonBackend) RetryDownload(id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, failedItem := range b.queue.Failed {
+		if failedItem.Id == id {
+			b.queue.Failed = append(b.queue.Failed[:i], b.queue.Failed[i+1:]...)
+
+			// Keep the failed item's resume state so a manual retry continues
+			// from BytesDownloaded instead of restarting the file from scratch.
+			item := failedItem.Item
+			b.queue.Pending = append(b.queue.Pending, item)
+
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *jsonBackend) MoveToDownloading(id string) (models.Item, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Pending {
+		if item.Id == id {
+			b.queue.Pending = append(b.queue.Pending[:i], b.queue.Pending[i+1:]...)
+			b.queue.Downloading = append(b.queue.Downloading, item)
+			b.saveQueue(b.queue)
+			return item, true, nil
+		}
+	}
+	return models.Item{}, false, nil
+}
+
+func (b *jsonBackend) ClaimPendingItem(allow func(models.Item) bool) (models.Item, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Pending {
+		if !allow(item) {
+			continue
+		}
+		b.queue.Pending = append(b.queue.Pending[:i], b.queue.Pending[i+1:]...)
+		b.queue.Downloading = append(b.queue.Downloading, item)
+		b.saveQueue(b.queue)
+		return item, true, nil
+	}
+	return models.Item{}, false, nil
+}
+
+func (b *jsonBackend) UpdateDownloadingItem(item models.Item) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, existing := range b.queue.Downloading {
+		if existing.Id == item.Id {
+			b.queue.Downloading[i] = item
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *jsonBackend) MoveToCompleted(downloadedItem models.Item) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Downloading {
+		if item.Id == downloadedItem.Id {
+			b.queue.Downloading = append(b.queue.Downloading[:i], b.queue.Downloading[i+1:]...)
+			b.queue.Completed = append(b.queue.Completed, downloadedItem)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *jsonBackend) MoveToFailed(failedItem models.Item, errMsg string, permanent bool, policy models.RetryPolicy) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.queue.Downloading {
+		if item.Id == failedItem.Id {
+			b.queue.Downloading = append(b.queue.Downloading[:i], b.queue.Downloading[i+1:]...)
+			b.applyRetryDecision(item, errMsg, permanent, policy)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+
+	for i, item := range b.queue.Pending {
+		if item.Id == failedItem.Id {
+			b.queue.Pending = append(b.queue.Pending[:i], b.queue.Pending[i+1:]...)
+			b.applyRetryDecision(item, errMsg, permanent, policy)
+			b.saveQueue(b.queue)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RecoverInterrupted moves any items still marked Downloading back onto the
+// front of Pending. loadQueue already does this once at startup, so it only
+// moves anything if called after ClaimPendingItem or MoveToDownloading has
+// run (the startup call in main comes before any worker claims an item), but
+// it keeps the guarantee explicit and queryable like boltBackend's.
+func (b *jsonBackend) RecoverInterrupted() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	moved := len(b.queue.Downloading)
+	if moved == 0 {
+		return 0, nil
+	}
+	b.queue.Pending = append(append([]models.Item{}, b.queue.Downloading...), b.queue.Pending...)
+	b.queue.Downloading = []models.Item{}
+	b.saveQueue(b.queue)
+	return moved, nil
+}
+
+// applyRetryDecision appends item to either Pending (requeued after backoff)
+// or Failed (dead-lettered), per decideRetry. Callers must hold b.mu.
+func (b *jsonBackend) applyRetryDecision(item models.Item, errMsg string, permanent bool, policy models.RetryPolicy) {
+	requeued, deadLettered := decideRetry(item, errMsg, permanent, policy)
+	if deadLettered != nil {
+		b.queue.Failed = append(b.queue.Failed, *deadLettered)
+		return
+	}
+	b.queue.Pending = append(b.queue.Pending, requeued)
+}
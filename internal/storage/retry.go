@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"fsqdgo/internal/models"
+)
+
+// DefaultRetryPolicy governs any queue that doesn't configure its own.
+var DefaultRetryPolicy = models.RetryPolicy{
+	MaxAttempts:           5,
+	InitialBackoffSeconds: 2,
+	MaxBackoffSeconds:     60,
+	Multiplier:            2,
+	Jitter:                0.5,
+}
+
+// resolvePolicy fills in any zero field of p with DefaultRetryPolicy's value,
+// so a queue only needs to override the parts it cares about.
+func resolvePolicy(p models.RetryPolicy) models.RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoffSeconds <= 0 {
+		p.InitialBackoffSeconds = DefaultRetryPolicy.InitialBackoffSeconds
+	}
+	if p.MaxBackoffSeconds <= 0 {
+		p.MaxBackoffSeconds = DefaultRetryPolicy.MaxBackoffSeconds
+	}
+	if p.Multiplier <= 0 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter <= 0 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// decideRetry applies policy to an item that just failed, returning either an
+// updated item to requeue onto Pending once its backoff elapses, or a
+// dead-lettered FailedItem if permanent is set or attempts are exhausted.
+func decideRetry(item models.Item, errMsg string, permanent bool, policy models.RetryPolicy) (requeued models.Item, deadLettered *models.FailedItem) {
+	policy = resolvePolicy(policy)
+	item.Attempts++
+
+	if permanent || item.Attempts >= policy.MaxAttempts {
+		item.NextAttemptAt = ""
+		// permanent marks a non-retryable error (4xx-equivalent, disk-full);
+		// an item that merely ran out of attempts on a retryable error is
+		// still dead-lettered, but tagged so it can be cleared separately.
+		return models.Item{}, &models.FailedItem{Item: item, Error: errMsg, Permanent: permanent}
+	}
+
+	backoff := policy.InitialBackoffSeconds * math.Pow(policy.Multiplier, float64(item.Attempts-1))
+	if backoff > policy.MaxBackoffSeconds {
+		backoff = policy.MaxBackoffSeconds
+	}
+	jitter := (rand.Float64()*2 - 1) * policy.Jitter * backoff
+	delay := time.Duration((backoff + jitter) * float64(time.Second))
+	if delay < 0 {
+		delay = 0
+	}
+
+	item.NextAttemptAt = time.Now().Add(delay).Format(time.RFC3339)
+	return item, nil
+}
+
+// readyForAttempt reports whether item's backoff (if any) has elapsed.
+func readyForAttempt(item models.Item) bool {
+	if item.NextAttemptAt == "" {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, item.NextAttemptAt)
+	if err != nil {
+		return true
+	}
+	return !t.After(time.Now())
+}
+
+// failedBucket classifies a FailedItem into the filter names ClearFailedItems accepts.
+func failedBucket(item models.FailedItem) string {
+	if item.Permanent {
+		return "permanent"
+	}
+	return "transient"
+}
+
+// matchesBucket reports whether item belongs to the requested clear filter;
+// "" and "all" match everything.
+func matchesBucket(item models.FailedItem, bucket string) bool {
+	switch bucket {
+	case "", "all":
+		return true
+	default:
+		return failedBucket(item) == bucket
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"testing"
+
+	"fsqdgo/internal/models"
+)
+
+func TestResolvePolicyFillsZeroFields(t *testing.T) {
+	got := resolvePolicy(models.RetryPolicy{})
+	if got != DefaultRetryPolicy {
+		t.Errorf("resolvePolicy(zero value) = %+v, want %+v", got, DefaultRetryPolicy)
+	}
+}
+
+func TestResolvePolicyKeepsOverrides(t *testing.T) {
+	p := models.RetryPolicy{MaxAttempts: 3}
+	got := resolvePolicy(p)
+
+	if got.MaxAttempts != 3 {
+		t.Errorf("MaxAttempts = %d, want 3 (override preserved)", got.MaxAttempts)
+	}
+	if got.InitialBackoffSeconds != DefaultRetryPolicy.InitialBackoffSeconds {
+		t.Errorf("InitialBackoffSeconds = %v, want default %v", got.InitialBackoffSeconds, DefaultRetryPolicy.InitialBackoffSeconds)
+	}
+}
+
+func TestDecideRetryPermanentDeadLettersImmediately(t *testing.T) {
+	item := models.Item{Id: "1", Attempts: 0}
+
+	requeued, deadLettered := decideRetry(item, "bad request", true, models.RetryPolicy{})
+
+	if deadLettered == nil {
+		t.Fatal("expected a dead-lettered item for a permanent error")
+	}
+	if requeued.Id != "" {
+		t.Errorf("requeued = %+v, want zero value", requeued)
+	}
+	if !deadLettered.Permanent {
+		t.Error("deadLettered.Permanent = false, want true")
+	}
+	if deadLettered.Error != "bad request" {
+		t.Errorf("deadLettered.Error = %q, want %q", deadLettered.Error, "bad request")
+	}
+	if deadLettered.Attempts != 1 {
+		t.Errorf("deadLettered.Attempts = %d, want 1", deadLettered.Attempts)
+	}
+}
+
+func TestDecideRetryExhaustedAttemptsDeadLetters(t *testing.T) {
+	policy := models.RetryPolicy{MaxAttempts: 2}
+	item := models.Item{Id: "1", Attempts: 1}
+
+	_, deadLettered := decideRetry(item, "timeout", false, policy)
+
+	if deadLettered == nil {
+		t.Fatal("expected a dead-lettered item once MaxAttempts is reached")
+	}
+	if deadLettered.Permanent {
+		t.Error("deadLettered.Permanent = true, want false (exhausted retries, not a permanent error)")
+	}
+}
+
+func TestDecideRetryRequeuesWithBackoff(t *testing.T) {
+	policy := models.RetryPolicy{MaxAttempts: 5}
+	item := models.Item{Id: "1", Attempts: 0}
+
+	requeued, deadLettered := decideRetry(item, "timeout", false, policy)
+
+	if deadLettered != nil {
+		t.Fatalf("expected a requeue, got dead-lettered item: %+v", deadLettered)
+	}
+	if requeued.Attempts != 1 {
+		t.Errorf("requeued.Attempts = %d, want 1", requeued.Attempts)
+	}
+	if requeued.NextAttemptAt == "" {
+		t.Error("requeued.NextAttemptAt is empty, want a scheduled retry time")
+	}
+}
@@ -2,217 +2,454 @@ package storage
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 
 	"fsqdgo/internal/models"
 )
 
+// DefaultQueue is the queue every item belongs to unless a caller explicitly
+// namespaces it into another one, keeping single-queue installs working
+// exactly as before multi-queue support existed.
+const DefaultQueue = "default"
+
+// queueConfigFile holds a namespaced queue's QueueConfig alongside its
+// bbolt/json store, so CreateQueue's settings survive a restart instead of
+// only living in the in-memory registry.
+const queueConfigFile = "config.json"
+
+// validQueueName matches the only characters allowed in a queue name. Names
+// come straight from the POST /queues body and get joined into a filesystem
+// path, so anything outside this allowlist (e.g. "../../etc") could escape
+// dataDir instead of staying confined to a subdirectory of it.
+var validQueueName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Storage is the queue's public API. It delegates persistence to whichever
+// Backend was selected at startup, so callers never need to know whether a
+// given queue lives in bbolt or a legacy queue.json. Each named queue gets
+// its own independent Backend instance, so items in one can't block or
+// interfere with another.
 type Storage struct {
-	mu       sync.RWMutex
-	filePath string
-	queue    models.Queue
+	mu      sync.RWMutex
+	dataDir string
+	queues  map[string]*queueEntry
+}
+
+type queueEntry struct {
+	backend Backend
+	config  models.QueueConfig
 }
 
 func New(dataDir string) *Storage {
 	os.MkdirAll(dataDir, os.ModePerm)
+	s := &Storage{
+		dataDir: dataDir,
+		queues: map[string]*queueEntry{
+			DefaultQueue: {
+				backend: newBackend(dataDir),
+				config:  models.QueueConfig{Name: DefaultQueue},
+			},
+		},
+	}
+	s.loadPersistedQueues()
+	return s
+}
 
-	store := &Storage{filePath: dataDir + "/queue.json"}
-	queue, err := store.LoadQueue()
+// loadPersistedQueues re-registers every namespaced queue a prior run created
+// via CreateQueue, by scanning dataDir/queues for subdirectories. Without
+// this, a restart would leave their data on disk but unreachable - backendFor
+// would report them as unknown and the downloader would never start workers
+// for them again.
+func (s *Storage) loadPersistedQueues() {
+	root := filepath.Join(s.dataDir, "queues")
+	entries, err := os.ReadDir(root)
 	if err != nil {
-		slog.Warn("Could not load existing queue, starting fresh", "error", err)
-		queue = models.Queue{}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !validQueueName.MatchString(entry.Name()) {
+			slog.Warn("Skipping queue directory with invalid name", "queue", entry.Name())
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		cfg, err := readQueueConfig(dir, entry.Name())
+		if err != nil {
+			slog.Warn("Failed to load persisted queue config", "queue", entry.Name(), "error", err)
+			continue
+		}
+		if !validQueueName.MatchString(cfg.Name) {
+			slog.Warn("Skipping persisted queue config with invalid name", "queue", cfg.Name)
+			continue
+		}
+		s.queues[cfg.Name] = &queueEntry{backend: newBackend(dir), config: cfg}
 	}
-	store.queue = queue
-	return store
 }
 
-func (s *Storage) SaveQueue(data models.Queue) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// readQueueConfig loads a queue's persisted config.json. Queue directories
+// created before this file existed don't have one; fall back to a bare
+// config named after the directory rather than skipping the queue entirely.
+func readQueueConfig(dir, name string) (models.QueueConfig, error) {
+	data, err := os.ReadFile(filepath.Join(dir, queueConfigFile))
+	if os.IsNotExist(err) {
+		return models.QueueConfig{Name: name}, nil
+	}
+	if err != nil {
+		return models.QueueConfig{}, err
+	}
 
-	storageData := models.QueueForStorage{
-		Pending:   data.Pending,
-		Completed: data.Completed,
-		Failed:    data.Failed,
+	var cfg models.QueueConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return models.QueueConfig{}, err
 	}
+	return cfg, nil
+}
 
-	file, err := os.Create(s.filePath)
+// writeQueueConfig persists cfg to dir/config.json so the next startup's
+// loadPersistedQueues can rehydrate it.
+func writeQueueConfig(dir string, cfg models.QueueConfig) error {
+	data, err := json.Marshal(cfg)
 	if err != nil {
-		slog.Error("Failed to save queue", "error", err)
 		return err
 	}
-	defer file.Close()
+	return os.WriteFile(filepath.Join(dir, queueConfigFile), data, 0644)
+}
+
+// newBackend picks the storage backend from STORAGE_BACKEND: "bolt" (the
+// default) for an embedded, transactional store, or "json" for small
+// installs that would rather keep a plain-text queue.json. If bbolt can't be
+// opened, it falls back to the JSON backend rather than refusing to start.
+func newBackend(dir string) Backend {
+	if strings.EqualFold(os.Getenv("STORAGE_BACKEND"), "json") {
+		return newJSONBackend(dir)
+	}
+
+	backend, err := newBoltBackend(dir)
+	if err != nil {
+		slog.Warn("Failed to open bolt store, falling back to JSON", "error", err)
+		return newJSONBackend(dir)
+	}
+	return backend
+}
+
+// CreateQueue registers a new namespaced queue backed by its own store under
+// a subdirectory of the data dir, so e.g. "movies" and "linux-isos" can each
+// have their own concurrency limit and never compete for the same slots.
+func (s *Storage) CreateQueue(cfg models.QueueConfig) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("queue name is required")
+	}
+	if !validQueueName.MatchString(cfg.Name) {
+		return fmt.Errorf("queue name %q is invalid: only letters, digits, '_' and '-' are allowed", cfg.Name)
+	}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(storageData)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.queues[cfg.Name]; exists {
+		return fmt.Errorf("queue %q already exists", cfg.Name)
+	}
+
+	if cfg.Destination == "" {
+		// Per-queue destination credentials are easiest to rotate via an env
+		// var scoped to the queue name, without needing a request body change.
+		cfg.Destination = os.Getenv("DESTINATION_" + strings.ToUpper(cfg.Name))
+	}
+
+	dir := filepath.Join(s.dataDir, "queues", cfg.Name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("create queue dir: %w", err)
+	}
+	if err := writeQueueConfig(dir, cfg); err != nil {
+		return fmt.Errorf("persist queue config: %w", err)
+	}
+
+	s.queues[cfg.Name] = &queueEntry{backend: newBackend(dir), config: cfg}
+	return nil
 }
 
-func (s *Storage) LoadQueue() (models.Queue, error) {
+// ListQueues returns every known queue's config, sorted by name.
+func (s *Storage) ListQueues() []models.QueueConfig {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var data models.QueueForStorage
-	file, err := os.Open(s.filePath)
-	if err != nil {
-		return models.Queue{}, err
+	queues := make([]models.QueueConfig, 0, len(s.queues))
+	for _, q := range s.queues {
+		queues = append(queues, q.config)
 	}
-	defer file.Close()
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues
+}
 
-	err = json.NewDecoder(file).Decode(&data)
-	if err != nil {
-		return models.Queue{}, err
+func (s *Storage) backendFor(queueName string) (Backend, bool) {
+	if queueName == "" {
+		// Empty means the default queue for items persisted before
+		// namespacing existed.
+		queueName = DefaultQueue
 	}
 
-	return models.Queue{
-		Downloading: []models.Item{},
-		Pending:     data.Pending,
-		Completed:   data.Completed,
-		Failed:      data.Failed,
-	}, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		return nil, false
+	}
+	return q.backend, true
 }
 
-func (s *Storage) GetQueue() models.Queue {
+// destinationOf returns queueName's configured default destination, or "" if
+// the queue is unknown or has none set.
+func (s *Storage) destinationOf(queueName string) string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.queue
+	q, ok := s.queues[queueName]
+	if !ok {
+		return ""
+	}
+	return q.config.Destination
 }
 
-func (s *Storage) AddPendingItem(item models.Item) {
-	s.queue.Pending = append(s.queue.Pending, item)
-	go s.SaveQueue(s.queue)
+func (s *Storage) GetQueue(queueName string) models.Queue {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return models.Queue{}
+	}
+
+	queue, err := backend.Load()
+	if err != nil {
+		slog.Error("Failed to load queue", "queue", queueName, "error", err)
+	}
+	return queue
 }
 
-func (s *Storage) RemoveItemById(id string) bool {
-	for i, item := range s.queue.Pending {
-		if item.Id == id {
-			s.queue.Pending = append(s.queue.Pending[:i], s.queue.Pending[i+1:]...)
-			go s.SaveQueue(s.queue)
-			return true
-		}
+func (s *Storage) AddPendingItem(queueName string, item models.Item) {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return
 	}
 
-	for i, item := range s.queue.Completed {
-		if item.Id == id {
-			s.queue.Completed = append(s.queue.Completed[:i], s.queue.Completed[i+1:]...)
-			go s.SaveQueue(s.queue)
-			return true
-		}
+	item.Queue = queueName
+	if item.Destination == "" {
+		item.Destination = s.destinationOf(queueName)
 	}
+	if err := backend.AddPendingItem(item); err != nil {
+		slog.Error("Failed to add pending item", "queue", queueName, "id", item.Id, "error", err)
+	}
+}
 
-	for i, item := range s.queue.Failed {
-		if item.Id == id {
-			s.queue.Failed = append(s.queue.Failed[:i], s.queue.Failed[i+1:]...)
-			go s.SaveQueue(s.queue)
-			return true
-		}
+func (s *Storage) RemoveItemById(queueName, id string) bool {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return false
 	}
 
-	return false
+	ok, err := backend.RemoveItemById(id)
+	if err != nil {
+		slog.Error("Failed to remove item", "queue", queueName, "id", id, "error", err)
+	}
+	return ok
 }
 
-func (s *Storage) MovePendingItem(id string, up bool) bool {
-	for i, item := range s.queue.Pending {
-		if item.Id == id {
-			if up && i > 0 {
-				s.queue.Pending[i-1], s.queue.Pending[i] = s.queue.Pending[i], s.queue.Pending[i-1]
-				go s.SaveQueue(s.queue)
-				return true
-			} else if !up && i < len(s.queue.Pending)-1 {
-				s.queue.Pending[i], s.queue.Pending[i+1] = s.queue.Pending[i+1], s.queue.Pending[i]
-				go s.SaveQueue(s.queue)
-				return true
-			}
-			return false
-		}
+func (s *Storage) MovePendingItem(queueName, id string, up bool) bool {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return false
 	}
-	return false
+
+	ok, err := backend.MovePendingItem(id, up)
+	if err != nil {
+		slog.Error("Failed to move pending item", "queue", queueName, "id", id, "error", err)
+	}
+	return ok
 }
 
-func (s *Storage) ClearFailedItems() {
-	s.queue.Failed = []models.FailedItem{}
-	go s.SaveQueue(s.queue)
+// ClearFailedItems removes Failed entries from queueName matching bucket
+// ("", "all", "transient", or "permanent").
+func (s *Storage) ClearFailedItems(queueName, bucket string) {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return
+	}
+
+	if err := backend.ClearFailedItems(bucket); err != nil {
+		slog.Error("Failed to clear failed items", "queue", queueName, "bucket", bucket, "error", err)
+	}
 }
 
-func (s *Storage) ClearCompletedItems() {
-	s.queue.Completed = []models.Item{}
-	go s.SaveQueue(s.queue)
+func (s *Storage) ClearCompletedItems(queueName string) {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return
+	}
+
+	if err := backend.ClearCompletedItems(); err != nil {
+		slog.Error("Failed to clear completed items", "queue", queueName, "error", err)
+	}
 }
 
-func (s *Storage) RetryDownload(id string) bool {
-	for i, failedItem := range s.queue.Failed {
-		if failedItem.Id == id {
-			s.queue.Failed = append(s.queue.Failed[:i], s.queue.Failed[i+1:]...)
+func (s *Storage) RetryDownload(queueName, id string) bool {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return false
+	}
 
-			item := models.Item{
-				Id:      failedItem.Id,
-				Link:    failedItem.Link,
-				Name:    failedItem.Name,
-				Size:    failedItem.Size,
-				AddedAt: failedItem.AddedAt,
-			}
+	ok, err := backend.RetryDownload(id)
+	if err != nil {
+		slog.Error("Failed to retry download", "queue", queueName, "id", id, "error", err)
+	}
+	return ok
+}
 
-			s.queue.Pending = append(s.queue.Pending, item)
+func (s *Storage) MoveToDownloading(queueName, id string) (*models.Item, bool) {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return nil, false
+	}
 
-			go s.SaveQueue(s.queue)
-			return true
-		}
+	item, ok, err := backend.MoveToDownloading(id)
+	if err != nil {
+		slog.Error("Failed to move item to downloading", "queue", queueName, "id", id, "error", err)
+	}
+	if !ok {
+		return nil, false
 	}
-	return false
+	item.Queue = queueName
+	return &item, true
 }
 
-func (s *Storage) MoveToDownloading(id string) (*models.Item, bool) {
-	for i, item := range s.queue.Pending {
-		if item.Id == id {
-			s.queue.Pending = append(s.queue.Pending[:i], s.queue.Pending[i+1:]...)
-			s.queue.Downloading = append(s.queue.Downloading, item)
-			go s.SaveQueue(s.queue)
-			return &item, true
-		}
+// ClaimPendingItem scans queueName's Pending list for the first item
+// accepted by allow and moves it straight to Downloading atomically, so two
+// workers can never claim the same item. allow lets the caller enforce its
+// own admission rules (e.g. a per-host concurrency cap) without blocking
+// items behind one another - an item further back in Pending can be claimed
+// even if the front of the queue is currently rejected.
+func (s *Storage) ClaimPendingItem(queueName string, allow func(models.Item) bool) (*models.Item, bool) {
+	backend, ok := s.backendFor(queueName)
+	if !ok {
+		slog.Error("Unknown queue", "queue", queueName)
+		return nil, false
+	}
+
+	// Skip items still serving out their retry backoff, so a slow-to-recover
+	// host doesn't get hammered on every worker's poll.
+	gated := func(item models.Item) bool {
+		return readyForAttempt(item) && allow(item)
+	}
+
+	item, ok, err := backend.ClaimPendingItem(gated)
+	if err != nil {
+		slog.Error("Failed to claim pending item", "queue", queueName, "error", err)
 	}
-	return nil, false
+	if !ok {
+		return nil, false
+	}
+	item.Queue = queueName
+	return &item, true
 }
 
-func (s *Storage) MoveToCompleted(downloadedItem models.Item) bool {
-	for i, item := range s.queue.Downloading {
-		if item.Id == downloadedItem.Id {
-			s.queue.Downloading = append(s.queue.Downloading[:i], s.queue.Downloading[i+1:]...)
-			s.queue.Completed = append(s.queue.Completed, downloadedItem)
-			go s.SaveQueue(s.queue)
-			return true
-		}
+// UpdateDownloadingItem overwrites the in-flight item matching item.Id with
+// the given snapshot (used to checkpoint resume progress while a download is
+// in progress), in whichever queue item.Queue names.
+func (s *Storage) UpdateDownloadingItem(item models.Item) bool {
+	backend, ok := s.backendFor(item.Queue)
+	if !ok {
+		slog.Error("Unknown queue", "queue", item.Queue)
+		return false
 	}
-	return false
+
+	ok, err := backend.UpdateDownloadingItem(item)
+	if err != nil {
+		slog.Error("Failed to checkpoint downloading item", "queue", item.Queue, "id", item.Id, "error", err)
+	}
+	return ok
 }
 
-func (s *Storage) MoveToFailed(failedItem models.Item, errMsg string) bool {
-	for i, item := range s.queue.Downloading {
-		if item.Id == failedItem.Id {
-			s.queue.Downloading = append(s.queue.Downloading[:i], s.queue.Downloading[i+1:]...)
+// UpdateProgress checkpoints an in-flight item's resume state (bytes written
+// so far) without needing the caller to build a whole Item snapshot itself.
+func (s *Storage) UpdateProgress(item models.Item, bytesDownloaded int64) bool {
+	item.BytesDownloaded = bytesDownloaded
+	return s.UpdateDownloadingItem(item)
+}
 
-			newItem := models.FailedItem{
-				Item:  item,
-				Error: errMsg,
-			}
-			s.queue.Failed = append(s.queue.Failed, newItem)
+func (s *Storage) MoveToCompleted(item models.Item) bool {
+	backend, ok := s.backendFor(item.Queue)
+	if !ok {
+		slog.Error("Unknown queue", "queue", item.Queue)
+		return false
+	}
 
-			go s.SaveQueue(s.queue)
-			return true
-		}
+	ok, err := backend.MoveToCompleted(item)
+	if err != nil {
+		slog.Error("Failed to move item to completed", "queue", item.Queue, "id", item.Id, "error", err)
+	}
+	return ok
+}
+
+// MoveToFailed requeues item (if its RetryPolicy allows another attempt) or
+// dead-letters it into Failed; permanent forces the dead-letter path.
+func (s *Storage) MoveToFailed(item models.Item, errMsg string, permanent bool) bool {
+	backend, ok := s.backendFor(item.Queue)
+	if !ok {
+		slog.Error("Unknown queue", "queue", item.Queue)
+		return false
 	}
 
-	for i, item := range s.queue.Pending {
-		if item.Id == failedItem.Id {
-			s.queue.Pending = append(s.queue.Pending[:i], s.queue.Pending[i+1:]...)
-			newItem := models.FailedItem{
-				Item:  item,
-				Error: errMsg,
-			}
-			s.queue.Failed = append(s.queue.Failed, newItem)
-			go s.SaveQueue(s.queue)
-			return true
+	ok, err := backend.MoveToFailed(item, errMsg, permanent, s.retryPolicyOf(item.Queue))
+	if err != nil {
+		slog.Error("Failed to move item to failed", "queue", item.Queue, "id", item.Id, "error", err)
+	}
+	return ok
+}
+
+// RecoverInterrupted moves any items left in Downloading back onto Pending in
+// every known queue, for a store (bolt) whose Load doesn't already do this
+// itself. Meant to be called once at startup, before the downloader starts
+// claiming work, so items orphaned by a crash aren't stuck forever.
+func (s *Storage) RecoverInterrupted() {
+	s.mu.RLock()
+	queues := make(map[string]Backend, len(s.queues))
+	for name, q := range s.queues {
+		queues[name] = q.backend
+	}
+	s.mu.RUnlock()
+
+	for name, backend := range queues {
+		moved, err := backend.RecoverInterrupted()
+		if err != nil {
+			slog.Error("Failed to recover interrupted downloads", "queue", name, "error", err)
+			continue
 		}
+		if moved > 0 {
+			slog.Info("Recovered interrupted downloads", "queue", name, "count", moved)
+		}
+	}
+}
+
+// retryPolicyOf returns queueName's configured RetryPolicy, or the zero value
+// (which resolvePolicy fills with DefaultRetryPolicy) if unknown.
+func (s *Storage) retryPolicyOf(queueName string) models.RetryPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		return models.RetryPolicy{}
 	}
-	return false
+	return q.config.RetryPolicy
 }
@@ -2,16 +2,17 @@ package utils
 
 import (
 	"log/slog"
-	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"fsqdgo/internal/httpclient"
 )
 
 func ExtractFileInfo(url string) (name string, sizeStr string) {
 	slog.Info("Extracting file info", "url", url)
-	res, err := http.Get(url)
+	res, err := httpclient.Client.Get(url)
 	if err != nil {
 		slog.Error("Failed to fetch URL", "error", err)
 		return "Unknown", "Unknown size"
@@ -81,6 +81,20 @@ func (h *Hub) BroadcastProgress(update *ProgressUpdate) {
 	h.broadcast <- msg
 }
 
+type BandwidthUpdate struct {
+	Type           string `json:"type"`
+	MaxBytesPerSec int64  `json:"maxBytesPerSec"`
+}
+
+func (h *Hub) BroadcastBandwidth(maxBytesPerSec int64) {
+	msg, err := json.Marshal(BandwidthUpdate{Type: "bandwidth", MaxBytesPerSec: maxBytesPerSec})
+	if err != nil {
+		slog.Error("Failed to marshal bandwidth update", "error", err)
+		return
+	}
+	h.broadcast <- msg
+}
+
 func (h *Hub) WsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {